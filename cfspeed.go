@@ -3,383 +3,576 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/tls"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"math/rand"
 	"net"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/GuangYu-yu/cfspeed/scan"
 	"github.com/cheggaaa/pb/v3"
 	"github.com/olekukonko/tablewriter"
-	"golang.org/x/sync/semaphore"
+	"gopkg.in/yaml.v3"
 )
 
-// ----------------------- 数据类型定义 -----------------------
+// ----------------------- 主程序入口 -----------------------
 
-type TestResult struct {
-	IP         string
-	CIDR       string
-	DataCenter string
-	Region     string
-	City       string
-	AvgLatency int // 直接存储毫秒值
-	LossRate   float64
-}
+// 版本号，构建时通过 -ldflags "-X main.version=..." 设置
+var version = "dev"
 
-func (r *TestResult) Clear() {
-	r.IP = ""
-	r.CIDR = ""
-	r.DataCenter = ""
-	r.Region = ""
-	r.City = ""
-	r.AvgLatency = 0
-	r.LossRate = 0
-}
+// 程序启动时间，写入 JSON 结果的元数据
+var startTime = time.Now()
 
-// 临时测试数据
-type CIDRTestData struct {
-	IPs     []string
-	Results []TestResult
-}
+// 实时输出的结果写入原始的标准输出，提示信息改为写入标准错误
+var streamOut = os.Stdout
 
-// 最终结果
-type CIDRResult struct {
-	CIDR       string
-	DataCenter string
-	Region     string
-	City       string
-	AvgLatency int
-	LossRate   float64
-}
+// 选取子网和IP使用的随机数生成器，由 initRand 根据 -seed 创建
+var rng *rand.Rand
+
+var (
+	// 命令行参数，由各子命令的 FlagSet 注册，未注册的参数为 nil
+	urlFlag        *string
+	cidrFlag       *string
+	fileFlag       *string
+	excludeFlag    *string
+	excludeFile    *string
+	split4Flag     *string
+	split6Flag     *string
+	maxGroups      *int
+	sampleGroups   *int
+	seedFlag       *int64
+	testCount      *int
+	portFlag       *int
+	modeFlag       *string
+	sniFlag        *string
+	dlCount        *int
+	dlURL          *string
+	dlTime         *int
+	ipPerCIDR      *int
+	adaptive       *bool
+	coloFlag       *string
+	maxLatency     *int
+	minLatency     *int
+	maxLossRate    *float64
+	filterMetric   *string
+	sortMetric     *string
+	scanThreads    *int
+	printCount     *string
+	outFile        *string
+	noCSV          *bool
+	formatFlag     *string
+	streamFlag     *string
+	ipOutFile      *string
+	useIPv4        *string
+	useIPv6        *string
+	ipTxtFile      *string
+	showAll        *bool
+	timeoutFlag    *string
+	checkpointFile *string
+	resume         *bool
+	locationsFile  *string
+	configFile     *string
+	profileFlag    *string
+
+	// 数据中心查询
+	coloMode   *string
+	coloScheme *string
+	coloPort   *int
+	coloHost   *string
+	coloSNI    *string
+	coloPath   *string
+	coloCache  *string
+	coloMaxAge *time.Duration
+
+	// 超时和重试
+	probeTimeout   *time.Duration
+	probeInterval  *time.Duration
+	coloTimeout    *time.Duration
+	coloRetries    *int
+	coloRetryDelay *time.Duration
+	urlTimeout     *time.Duration
+	urlRetries     *int
+	urlRetryDelay  *time.Duration
+)
 
-// 测试过程中的结构
-type CIDRGroup struct {
-	CIDR   string
-	Data   *CIDRTestData // 临时数据
-	Result *TestResult
+// 注册CIDR来源参数，scan 和 gen 共用
+func addSourceFlags(fs *flag.FlagSet) {
+	fetchDef := scan.DefaultFetchOptions()
+	urlFlag = fs.String("url", "", "CIDR列表链接")
+	cidrFlag = fs.String("cidr", "", "手动指定CIDR，多个用逗号分隔 (例: 104.16.0.0/13,2606:4700::/32)")
+	fileFlag = fs.String("f", "", "CIDR列表文件")
+	excludeFlag = fs.String("exclude", "", "排除的CIDR或IP，多个用逗号分隔 (例: 104.16.0.0/24,104.17.0.1)")
+	excludeFile = fs.String("exclude-file", "", "排除的CIDR列表文件")
+	split4Flag = fs.String("split4", strconv.Itoa(scan.DefaultSplitIPv4), "IPv4 CIDR拆分后的前缀长度，none 表示不拆分")
+	split6Flag = fs.String("split6", strconv.Itoa(scan.DefaultSplitIPv6), "IPv6 CIDR拆分后的前缀长度，none 表示不拆分")
+	maxGroups = fs.Int("max-groups", scan.DefaultMaxGroups, "拆分后的CIDR数量上限，0 表示不限制")
+	sampleGroups = fs.Int("sample-groups", 0, "每个CIDR拆分后随机选取的子网数量，0 表示使用全部子网")
+	seedFlag = fs.Int64("seed", 0, "随机种子，未指定时使用当前时间")
+	urlTimeout = fs.Duration("utimeout", fetchDef.Timeout, "获取CIDR链接超时")
+	urlRetries = fs.Int("uretry", fetchDef.Retries, "获取CIDR链接最大尝试次数")
+	urlRetryDelay = fs.Duration("udelay", fetchDef.RetryDelay, "获取CIDR链接重试间隔")
 }
 
-type location struct {
-	Iata   string `json:"iata"`
-	Region string `json:"region"`
-	City   string `json:"city"`
+// 注册IP列表参数，scan 和 gen 共用
+func addIPListFlags(fs *flag.FlagSet) {
+	useIPv4 = fs.String("useip4", "", "输出IPv4列表，使用 all 表示输出所有IPv4")
+	useIPv6 = fs.String("useip6", "", "输出IPv6列表，使用 all 表示输出所有IPv6")
+	ipTxtFile = fs.String("iptxt", "ip.txt", "指定IP列表输出文件名")
 }
 
-// ----------------------- 主程序入口 -----------------------
+// 注册数据中心查询参数，scan 和 colo 共用
+func addColoFlags(fs *flag.FlagSet) {
+	def := scan.DefaultOptions()
+	locationsFile = fs.String("locations", "", "从本地JSON文件读取数据中心位置信息，不联网获取")
+	coloMode = fs.String("cmode", scan.ColoModeRay, "数据中心查询方式，可选: ray, trace")
+	coloScheme = fs.String("cscheme", "http", "数据中心查询使用的协议，可选: http, https")
+	coloPort = fs.Int("cport", 0, "数据中心查询使用的端口，0 表示使用协议的默认端口")
+	coloHost = fs.String("chost", scan.DefaultColoHost, "数据中心查询请求的 Host")
+	coloSNI = fs.String("csni", "", "数据中心查询使用的SNI，默认与 -chost 相同")
+	coloPath = fs.String("cpath", "", "数据中心查询请求的路径，默认 ray 方式为 /，trace 方式为 /cdn-cgi/trace")
+	coloTimeout = fs.Duration("ctimeout", def.ColoTimeout, "数据中心查询超时")
+	coloRetries = fs.Int("cretry", def.ColoRetries, "数据中心查询重试次数")
+	coloRetryDelay = fs.Duration("cdelay", def.ColoRetryDelay, "数据中心查询重试间隔")
+}
 
-var (
-	globalSem      *semaphore.Weighted
-	testDataPool   sync.Pool
-	resultPool     sync.Pool
-	testResultPool sync.Pool
-	cidrStringPool sync.Map // CIDR 字符串池
-)
+// 注册配置文件参数，scan、gen 和 colo 共用
+func addConfigFlags(fs *flag.FlagSet) {
+	configFile = fs.String("config", "", "YAML配置文件，参数名与命令行参数相同")
+	profileFlag = fs.String("profile", "", "使用配置文件 profiles 中的指定配置")
+}
 
-var (
-	// 命令行参数
-	urlFlag     *string
-	cidrFlag    *string
-	fileFlag    *string
-	testCount   *int
-	portFlag    *int
-	ipPerCIDR   *int
-	coloFlag    *string
-	maxLatency  *int
-	minLatency  *int
-	maxLossRate *float64
-	scanThreads *int
-	printCount  *string
-	outFile     *string
-	noCSV       *bool
-	useIPv4     *string
-	useIPv6     *string
-	ipTxtFile   *string
-	noTest      *bool
-	showAll     *bool
-	help        *bool
-	timeoutFlag *string
-)
+// scan 命令的参数
+func newScanFlagSet() *flag.FlagSet {
+	def := scan.DefaultOptions()
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	fs.Usage = printScanHelp
+
+	addSourceFlags(fs)
+	addIPListFlags(fs)
+	addColoFlags(fs)
+
+	testCount = fs.Int("t", def.TestCount, "延迟测速的次数")
+	portFlag = fs.Int("tp", def.Port, "指定测速的端口号")
+	modeFlag = fs.String("mode", scan.ModeTCP, "探测方式，可选: tcp, tls")
+	sniFlag = fs.String("sni", scan.DefaultSNI, "tls 模式使用的SNI")
+	dlCount = fs.Int("dn", 0, "对延迟最低的前N个结果进行下载测速，0 表示不测速")
+	dlURL = fs.String("durl", scan.DefaultDownloadURL, "下载测速地址")
+	dlTime = fs.Int("dt", 10, "单个IP下载测速时长(秒)")
+	ipPerCIDR = fs.Int("ts", def.IPPerCIDR, "从CIDR内随机选择的不重复IP数量")
+	adaptive = fs.Bool("adaptive", false, "自适应测速，先粗筛再对通过的CIDR进行完整测试")
+	coloFlag = fs.String("colo", "", "匹配指定数据中心，用逗号分隔，例如 HKG,KHH,NRT,LAX")
+	maxLatency = fs.Int("tl", int(def.MaxLatency/time.Millisecond), "延迟上限(ms)")
+	minLatency = fs.Int("tll", int(def.MinLatency/time.Millisecond), "延迟下限(ms)")
+	filterMetric = fs.String("tlm", scan.MetricAvg, "延迟筛选使用的指标，可选: "+strings.Join(scan.Metrics, ", "))
+	sortMetric = fs.String("sort", scan.MetricAvg, "结果排序使用的延迟指标，可选: "+strings.Join(scan.Metrics, ", "))
+	maxLossRate = fs.Float64("tlr", def.MaxLossRate, "丢包率上限")
+	scanThreads = fs.Int("n", def.Threads, "并发数")
+	printCount = fs.String("p", "all", "输出延迟最低的CIDR数量")
+	outFile = fs.String("o", "IP_Speed.csv", "写入结果文件")
+	noCSV = fs.Bool("nocsv", false, "不输出CSV文件")
+	formatFlag = fs.String("format", "csv", "结果文件格式，多个用逗号分隔，可选: csv, json, ndjson")
+	streamFlag = fs.String("stream", "", "测试过程中将结果以NDJSON实时输出到标准输出，可选: cidr, ip")
+	ipOutFile = fs.String("ipout", "", "输出每个IP的测试结果，.json 结尾时输出JSON，否则输出CSV")
+	showAll = fs.Bool("showall", false, "使用后显示所有结果，包括未查询到数据中心的结果")
+	timeoutFlag = fs.String("timeout", "", "程序执行超时退出 (例: 5h0m0s，默认: 不使用)")
+	checkpointFile = fs.String("checkpoint", "", "断点文件，测速过程中定期保存已完成的CIDR")
+	resume = fs.Bool("resume", false, "从断点文件继续上次未完成的测速")
+	addConfigFlags(fs)
+	coloCache = fs.String("ccache", "", "数据中心缓存文件，默认保存在用户缓存目录")
+	coloMaxAge = fs.Duration("cmaxage", 0, "数据中心缓存有效期，0 表示不使用缓存")
+	probeTimeout = fs.Duration("ptimeout", time.Second, "单次探测超时")
+	probeInterval = fs.Duration("pinterval", 0, "同一IP两次探测之间的间隔")
+	return fs
+}
 
-// 获取共享 CIDR 字符串的函数
-func getSharedCIDR(cidr string) string {
-	if pooledCIDR, ok := cidrStringPool.Load(cidr); ok {
-		return pooledCIDR.(string)
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		printUsage()
+		return
 	}
-	cidrStringPool.Store(cidr, cidr)
-	return cidr
-}
 
-func init() {
-
-	// 初始化命令行参数
-	urlFlag = flag.String("url", "", "测速的CIDR链接")
-	cidrFlag = flag.String("cidr", "", "手动指定CIDR，多个用逗号分隔 (例: 104.16.0.0/13,2606:4700::/32)")
-	fileFlag = flag.String("f", "", "指定测速的文件")
-	testCount = flag.Int("t", 4, "延迟测速的次数")
-	portFlag = flag.Int("tp", 443, "指定测速的端口号")
-	ipPerCIDR = flag.Int("ts", 2, "从CIDR内随机选择IP的数量")
-	coloFlag = flag.String("colo", "", "匹配指定数据中心，用逗号分隔，例如 HKG,KHH,NRT,LAX")
-	maxLatency = flag.Int("tl", 500, "平均延迟上限(ms)")
-	minLatency = flag.Int("tll", 0, "平均延迟下限(ms)")
-	maxLossRate = flag.Float64("tlr", 0.5, "丢包率上限")
-	scanThreads = flag.Int("n", 128, "并发数")
-	printCount = flag.String("p", "all", "输出延迟最低的CIDR数量")
-	outFile = flag.String("o", "IP_Speed.csv", "写入结果文件")
-	noCSV = flag.Bool("nocsv", false, "不输出CSV文件")
-	useIPv4 = flag.String("useip4", "", "输出IPv4列表，使用 all 表示输出所有IPv4")
-	useIPv6 = flag.String("useip6", "", "输出IPv6列表，使用 all 表示输出所有IPv6")
-	ipTxtFile = flag.String("iptxt", "ip.txt", "指定IP列表输出文件名")
-	noTest = flag.Bool("notest", false, "不进行测速，只生成随机IP")
-	showAll = flag.Bool("showall", false, "使用后显示所有结果，包括未查询到数据中心的结果")
-	help = flag.Bool("h", false, "打印帮助")
-	timeoutFlag = flag.String("timeout", "", "程序执行超时退出 (例: 5h0m0s，默认: 不使用)")
-
-	// 初始化对象池
-	testDataPool = sync.Pool{
-		New: func() interface{} {
-			return &CIDRTestData{
-				IPs:     make([]string, 0),
-				Results: make([]TestResult, 0),
-			}
-		},
+	// 第一个参数不是子命令时按 scan 处理，兼容不使用子命令的旧用法，
+	// 旧的 -notest 参数转为 gen 命令
+	command := "scan"
+	switch args[0] {
+	case "-h", "-help", "--help":
+		command = "help"
+	default:
+		if !strings.HasPrefix(args[0], "-") {
+			command, args = args[0], args[1:]
+		} else if rest, noTest := takeNoTestFlag(args); noTest {
+			fmt.Println("提示: -notest 参数已弃用，请改用 cfspeed gen")
+			command, args = "gen", rest
+		} else {
+			args = rest
+		}
 	}
 
-	// 初始化 resultPool
-	resultPool = sync.Pool{
-		New: func() interface{} {
-			return &TestResult{}
-		},
+	switch command {
+	case "scan":
+		runScanCommand(args)
+	case "gen":
+		runGenCommand(args)
+	case "colo":
+		runColoCommand(args)
+	case "locations":
+		runLocationsCommand(args)
+	case "report":
+		runReportCommand(args)
+	case "help":
+		printUsage()
+	default:
+		fmt.Printf("未知的命令: %s\n", command)
+		printUsage()
+		os.Exit(2)
 	}
+}
 
-	// 初始化 TestResult 对象池
-	testResultPool = sync.Pool{
-		New: func() interface{} {
-			return &TestResult{}
-		},
+// 读取 -config 指定的配置文件，命令行中明确指定的参数优先。
+// shared 中的参数不属于当前子命令时忽略，以便 scan、gen 和 colo 共用同一个配置文件
+func applyConfig(fs *flag.FlagSet, shared map[string]bool) {
+	if *configFile != "" {
+		if err := loadConfig(fs, *configFile, *profileFlag, shared); err != nil {
+			fmt.Printf("读取配置文件失败: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *profileFlag != "" {
+		fmt.Println("错误: 使用 -profile 参数时必须指定 -config")
+		os.Exit(1)
 	}
 }
 
-// shouldIncludeResult 检查结果是否符合过滤条件
-func shouldIncludeResult(result TestResult, coloFlag *string, minLatency, maxLatency *int, maxLossRate *float64, showAll *bool) bool {
-	// 如果不显示所有结果，则跳过未知数据中心的结果
-	if !*showAll && result.DataCenter == "Unknown" {
-		return false
-	}
-
-	// 检查数据中心
-	if *coloFlag != "" {
-		coloList := strings.Split(*coloFlag, ",")
-		match := false
-		for _, colo := range coloList {
-			if result.DataCenter == strings.TrimSpace(colo) {
-				match = true
-				break
-			}
+// 返回 scan 命令的全部参数名，gen 和 colo 的参数都包含在内。
+// 会重新注册参数变量，必须在注册子命令自己的参数之前调用
+func scanFlagNames() map[string]bool {
+	names := make(map[string]bool)
+	newScanFlagSet().VisitAll(func(f *flag.Flag) {
+		names[f.Name] = true
+	})
+	return names
+}
+
+// 从旧用法的参数中取出已弃用的 -notest，返回其余参数和 -notest 的值
+func takeNoTestFlag(args []string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	noTest := false
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "notest" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			noTest = true
+			continue
 		}
-		if !match {
-			return false
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			rest = append(rest, arg)
+			continue
 		}
+		noTest = v
 	}
+	return rest, noTest
+}
 
-	// 检查延迟
-	if result.AvgLatency < *minLatency || result.AvgLatency > *maxLatency {
-		return false
-	}
+// 参数是否在命令行或配置文件中明确指定
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	// 检查丢包率
-	if result.LossRate > *maxLossRate {
-		return false
+// 解析子命令的参数，不接受 maxArgs 个以上的位置参数，maxArgs 为 -1 时不限制
+func parseFlags(fs *flag.FlagSet, args []string, maxArgs int) {
+	fs.Parse(args)
+	if maxArgs >= 0 && fs.NArg() > maxArgs {
+		fmt.Printf("错误: 无法识别的参数: %s\n", strings.Join(fs.Args()[maxArgs:], " "))
+		fs.Usage()
+		os.Exit(2)
 	}
-
-	return true
 }
 
-func main() {
+// cfspeed scan: 测速
+func runScanCommand(args []string) {
+	fs := newScanFlagSet()
+	parseFlags(fs, args, 0)
+	applyConfig(fs, nil)
 
-	// 解析命令行参数
-	flag.Parse()
+	// 实时输出时标准输出只用于结果，其余输出(包括进度条)都写入标准错误
+	if *streamFlag != "" {
+		os.Stdout = os.Stderr
+	}
 
-	// 创建一个可取消的上下文
-	var ctx context.Context
-	var cancel context.CancelFunc
+	// 收到中断信号时取消上下文
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// 解析超时时间
-	if *timeoutFlag == "" {
-		// 不限制运行时间
-		ctx, cancel = context.WithCancel(context.Background())
-	} else {
-		// 解析超时时间
+	if *timeoutFlag != "" {
 		timeout, err := time.ParseDuration(*timeoutFlag)
 		if err != nil {
 			fmt.Printf("解析超时时间失败: %v，将不限制运行时间\n", err)
-			ctx, cancel = context.WithCancel(context.Background())
 		} else {
-			ctx, cancel = context.WithTimeout(context.Background(), timeout)
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
 			fmt.Printf("程序将在 %s 后自动退出\n", formatDuration(timeout))
 		}
 	}
-	defer cancel()
 
-	// 创建一个通道用于接收程序完成信号
-	done := make(chan bool)
-
-	// 在后台运行主程序逻辑
+	// 超时或中断时提示用户，之后再次中断将直接退出
+	finished := make(chan struct{})
 	go func() {
-		// 主程序逻辑
-		runMainProgram()
-		done <- true
+		select {
+		case <-ctx.Done():
+			stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				fmt.Println("\n程序执行超时，等待进行中的测试完成后输出已完成的结果")
+			} else {
+				fmt.Println("\n收到中断信号，等待进行中的测试完成后输出已完成的结果，再次中断将强制退出")
+			}
+		case <-finished:
+		}
 	}()
 
-	// 等待程序完成或超时
-	select {
-	case <-done:
-		// 程序正常完成
-		fmt.Println("程序执行完成")
-	case <-ctx.Done():
-		// 程序超时
-		fmt.Println("程序执行超时，强制退出")
+	// 主程序逻辑
+	runScan(ctx, fs)
+	close(finished)
+
+	if ctx.Err() != nil {
+		fmt.Println("程序已中断，已完成的结果已输出")
+		stop()
 		os.Exit(1)
 	}
+	fmt.Println("程序执行完成")
 }
 
-func runMainProgram() {
+func runScan(ctx context.Context, fs *flag.FlagSet) {
 
-	// 限制最大并发数
-	if *scanThreads > 1024 {
-		// 限制最大并发数为1024
-		*scanThreads = 1024
+	// 检查必要参数
+	if !hasSource() {
+		fmt.Println("错误: 必须至少指定 -url、-f 或 -cidr 其中的一个参数")
+		printScanHelp()
+		return
 	}
 
-	// 使用统一的并发控制
-	maxConcurrent := *scanThreads
-	globalSem = semaphore.NewWeighted(int64(maxConcurrent))
-
-	// 显示帮助信息
-	if *help {
-		printHelp()
+	// 检查测试次数和每个CIDR测试的IP数量
+	if *testCount < 1 {
+		fmt.Println("错误: -t 必须大于 0")
 		return
 	}
-
-	// 检查必要参数
-	if *urlFlag == "" && *fileFlag == "" && *cidrFlag == "" {
-		fmt.Println("错误: 必须至少指定 -url、-f 或 -cidr 其中的一个参数")
-		printHelp()
+	if *ipPerCIDR < 1 {
+		fmt.Println("错误: -ts 必须大于 0")
 		return
 	}
 
-	// 如果使用 -notest 参数，检查是否指定了 -useip4 或 -useip6
-	if *noTest && *useIPv4 == "" && *useIPv6 == "" {
-		fmt.Println("错误: 使用 -notest 参数时必须至少指定 -useip4 或 -useip6 参数")
+	// 获取CIDR列表
+	cidrList, err := loadCIDRList(ctx)
+	if err != nil {
+		fmt.Printf("获取CIDR列表失败: %v\n", err)
 		return
 	}
 
-	// 获取CIDR列表
-	var cidrList []string
-	var err error
+	fmt.Printf("共获取到 %d 个CIDR\n", len(cidrList))
 
-	if *cidrFlag != "" {
-		// 处理手动指定的CIDR
-		cidrList = strings.Split(*cidrFlag, ",")
-		fmt.Printf("从命令行参数获取 %d 个CIDR\n", len(cidrList))
-	} else if *urlFlag != "" {
-		fmt.Printf("从URL获取CIDR列表: %s\n", *urlFlag)
-		cidrList, err = getCIDRFromURL(*urlFlag)
-	} else {
-		fmt.Printf("从文件获取CIDR列表: %s\n", *fileFlag)
-		cidrList, err = getCIDRFromFile(*fileFlag)
+	// 处理CIDR列表，按 -split4 和 -split6 拆分为测试组
+	initRand(fs)
+	expandedCIDRs, err := splitCIDRList(cidrList)
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+		return
 	}
+	fmt.Printf("处理后共有 %d 个CIDR\n", len(expandedCIDRs))
 
+	// 检查输出格式
+	formats, err := parseFormats(*formatFlag)
 	if err != nil {
-		fmt.Printf("获取CIDR列表失败: %v\n", err)
+		fmt.Printf("错误: %v\n", err)
 		return
 	}
 
-	fmt.Printf("共获取到 %d 个CIDR\n", len(cidrList))
+	// 检查实时输出方式
+	var onResult, onIPResult func(scan.TestResult)
+	switch *streamFlag {
+	case "":
+	case "cidr":
+		onResult = streamResult
+	case "ip":
+		onIPResult = streamResult
+	default:
+		fmt.Printf("错误: 不支持的实时输出方式: %s\n", *streamFlag)
+		return
+	}
 
-	// 处理CIDR列表，将大于/24的IPv4 CIDR拆分为多个/24，将大于/48的IPv6 CIDR拆分为多个/48
-	expandedCIDRs := expandCIDRs(cidrList)
-	fmt.Printf("处理后共有 %d 个CIDR\n", len(expandedCIDRs))
+	// 检查数据中心查询方式
+	if err = checkColoFlags(); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		return
+	}
 
-	// 如果指定了 -notest 参数，直接生成IP文件并退出
-	if *noTest {
-		var results []TestResult
-		for _, cidr := range expandedCIDRs {
-			_, _, parseErr := net.ParseCIDR(cidr)
-			if parseErr != nil {
-				continue
-			}
-			results = append(results, TestResult{
-				CIDR: cidr,
-			})
+	// 检查延迟指标
+	for _, metric := range []string{*filterMetric, *sortMetric} {
+		if err = scan.CheckMetric(metric); err != nil {
+			fmt.Printf("错误: %v\n", err)
+			return
 		}
+	}
 
-		fmt.Printf("跳过测速，直接生成IP列表\n")
-		err = generateIPFile(results, *useIPv4, *useIPv6, *ipTxtFile)
-		if err != nil {
-			fmt.Printf("生成IP文件失败: %v\n", err)
-		} else {
-			fmt.Printf("IP列表已写入: %s\n", *ipTxtFile)
-		}
+	// 创建探测方式
+	prober, err := scan.NewProber(*modeFlag, scan.ProberConfig{
+		Timeout:  *probeTimeout,
+		Interval: *probeInterval,
+		SNI:      *sniFlag,
+	})
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
 		return
 	}
 
 	// 获取Cloudflare数据中心位置信息
-	locationMap, err := getLocationMap()
+	locationMap, err := scan.LoadLocations(scan.LocationOptions{
+		File: *locationsFile,
+		Logf: logf,
+	})
 	if err != nil {
 		fmt.Printf("获取数据中心位置信息失败: %v\n", err)
 		return
 	}
 
-	// 从每个CIDR中随机选择IP进行测试
-	cidrGroups := make([]CIDRGroup, len(expandedCIDRs))
-	for i, cidr := range expandedCIDRs {
-		cidrGroups[i] = CIDRGroup{
-			CIDR: cidr,
-		}
-	}
-
 	if *timeoutFlag == "" {
 		fmt.Printf("程序将不会超时退出\n")
 	}
 
+	// 读取数据中心缓存
+	var coloCacheData *scan.ColoCache
+	if *coloMaxAge > 0 {
+		cacheFile := *coloCache
+		if cacheFile == "" {
+			if cacheFile, err = scan.DefaultColoCacheFile(); err != nil {
+				fmt.Printf("无法确定缓存目录，请使用 -ccache 指定数据中心缓存文件: %v\n", err)
+				return
+			}
+		}
+		coloCacheData, err = scan.LoadColoCache(cacheFile, *coloMaxAge)
+		if err != nil {
+			fmt.Printf("读取数据中心缓存失败: %v\n", err)
+			return
+		}
+		fmt.Printf("已读取数据中心缓存: %d 条记录未过期\n", coloCacheData.Len())
+	}
+
+	// 读取或创建断点
+	var checkpoint *scan.Checkpoint
+	if *checkpointFile != "" {
+		params := checkpointParams()
+		if *resume {
+			checkpoint, err = scan.LoadCheckpoint(*checkpointFile)
+			if os.IsNotExist(err) {
+				fmt.Printf("未找到断点文件 %s，将重新开始测速\n", *checkpointFile)
+				checkpoint = scan.NewCheckpoint(*checkpointFile, params)
+			} else if err != nil {
+				fmt.Printf("读取断点文件失败: %v\n", err)
+				return
+			} else if err = checkpoint.CheckParams(params); err != nil {
+				fmt.Printf("错误: 无法从断点文件 %s 继续，%v\n", *checkpointFile, err)
+				return
+			} else {
+				fmt.Printf("已读取断点文件: %d 个CIDR已完成测速\n", checkpoint.Len())
+			}
+		} else {
+			// 不覆盖上次中断时留下的断点
+			if _, err := os.Stat(*checkpointFile); err == nil {
+				fmt.Printf("错误: 断点文件 %s 已存在，使用 -resume 继续上次的测速，或删除该文件后重新开始\n", *checkpointFile)
+				return
+			}
+			checkpoint = scan.NewCheckpoint(*checkpointFile, params)
+		}
+	} else if *resume {
+		fmt.Println("错误: 使用 -resume 参数时 -checkpoint 不能为空")
+		return
+	}
+
 	// 测试IP性能
-	cidrGroups = testIPs(cidrGroups, *portFlag, *testCount, *scanThreads, *ipPerCIDR, locationMap,
-		coloFlag, minLatency, maxLatency, maxLossRate, showAll)
-
-	// 收集已合并的结果
-	var filteredResults []TestResult
-	for _, group := range cidrGroups {
-		if group.Result != nil {
-			// 直接添加 Result 的副本，不需要转换
-			filteredResults = append(filteredResults, *group.Result)
-
-			// 回收 Result 对象
-			testResultPool.Put(group.Result)
-			group.Result = nil
+	progress := newProgressBar()
+	opts := scan.Options{
+		Port:        *portFlag,
+		TestCount:   *testCount,
+		IPPerCIDR:   *ipPerCIDR,
+		Threads:     *scanThreads,
+		Colo:        splitList(*coloFlag),
+		MinLatency:  time.Duration(*minLatency) * time.Millisecond,
+		MaxLatency:  time.Duration(*maxLatency) * time.Millisecond,
+		MaxLossRate: *maxLossRate,
+		ShowAll:     *showAll,
+		Locations:   locationMap,
+		ColoCache:   coloCacheData,
+
+		Prober:   prober,
+		Rand:     rng,
+		Logf:     logf,
+		Progress: progress.update,
+
+		OnResult:   onResult,
+		OnIPResult: onIPResult,
+
+		Checkpoint:    checkpoint,
+		KeepIPResults: *ipOutFile != "",
+		Adaptive:      *adaptive,
+		FilterMetric:  *filterMetric,
+		SortMetric:    *sortMetric,
+	}
+	applyColoFlags(&opts)
+	scanner := scan.New(opts)
+	filteredResults, err := scanner.Run(ctx, expandedCIDRs)
+	progress.finish()
+
+	// 测速全部完成后不再需要断点
+	if err != nil {
+		fmt.Printf("测速未完成: %v\n", err)
+		if checkpoint != nil {
+			fmt.Printf("断点已保存到 %s，可使用相同参数加 -resume 继续\n", *checkpointFile)
+		}
+	} else if checkpoint != nil {
+		if err = checkpoint.Remove(); err != nil {
+			fmt.Printf("删除断点文件失败: %v\n", err)
 		}
 	}
 
 	// 过滤结果
 	fmt.Printf("符合条件的结果: %d 个\n", len(filteredResults))
 
-	// 排序结果
-	sort.Slice(filteredResults, func(i, j int) bool {
-		if filteredResults[i].LossRate == filteredResults[j].LossRate {
-			return filteredResults[i].AvgLatency < filteredResults[j].AvgLatency
+	// 下载测速
+	if *dlCount > 0 && len(filteredResults) > 0 {
+		fmt.Printf("开始下载测速: %s\n", *dlURL)
+		speedTest := &scan.SpeedTest{
+			URL:      *dlURL,
+			Duration: time.Duration(*dlTime) * time.Second,
+			Logf:     logf,
 		}
-		return filteredResults[i].LossRate < filteredResults[j].LossRate
-	})
+		speedTest.Run(ctx, filteredResults, *dlCount)
+	}
 
 	// 限制输出数量
 	if *printCount != "all" {
@@ -394,18 +587,37 @@ func runMainProgram() {
 	}
 
 	// 输出结果
-	if !*noCSV {
-		err = writeResultsToCSV(filteredResults, *outFile)
+	for _, format := range formats {
+		filename := outputFileName(*outFile, format, formats, isFlagSet(fs, "o"))
+		switch format {
+		case "csv":
+			err = writeResultsToCSV(filteredResults, filename)
+		case "json":
+			err = writeResultsToJSON(filteredResults, filename, fs)
+		case "ndjson":
+			err = writeResultsToNDJSON(filteredResults, filename)
+		}
 		if err != nil {
-			fmt.Printf("写入CSV文件失败: %v\n", err)
+			fmt.Printf("写入%s文件失败: %v\n", strings.ToUpper(format), err)
 		} else {
-			fmt.Printf("结果已写入: %s\n", *outFile)
+			fmt.Printf("结果已写入: %s\n", filename)
+		}
+	}
+
+	// 输出每个IP的结果
+	if *ipOutFile != "" {
+		ipResults := scanner.IPResults()
+		err = writeIPResults(ipResults, *ipOutFile)
+		if err != nil {
+			fmt.Printf("写入IP结果文件失败: %v\n", err)
+		} else {
+			fmt.Printf("%d 个IP的结果已写入: %s\n", len(ipResults), *ipOutFile)
 		}
 	}
 
 	// 输出IP列表
 	if *useIPv4 != "" || *useIPv6 != "" {
-		err = generateIPFile(filteredResults, *useIPv4, *useIPv6, *ipTxtFile)
+		err = scan.GenerateIPFile(filteredResults, *useIPv4, *useIPv6, *ipTxtFile, rng, logf)
 		if err != nil {
 			fmt.Printf("生成IP文件失败: %v\n", err)
 		} else {
@@ -414,1135 +626,924 @@ func runMainProgram() {
 	}
 
 	// 打印结果摘要
-	printResultsSummary(filteredResults)
-}
-
-// ----------------------- 功能模块 -----------------------
-
-// 打印帮助信息
-func printHelp() {
-	fmt.Println("\n基本参数:")
-	fmt.Println("  -url      string      测速的CIDR链接")
-	fmt.Println("  -cidr     string      手动指定CIDR，多个用逗号分隔 (例: 104.16.0.0/13,2606:4700::/36)")
-	fmt.Println("  -f        string      指定测速的文件路径 (当未设置-url时使用)")
-	fmt.Println("  -o        string      结果文件名 (默认: IP_Speed.csv)")
-	fmt.Println("  -h                    显示帮助信息")
-	fmt.Println("  -notest               不进行测速，只生成随机IP (需配合 -useip4 或 -useip6 使用)")
-	fmt.Println("  -showall              使用后显示所有结果，包括未查询到数据中心的结果")
-	fmt.Println("  -timeout  string      程序执行超时退出 (例: 5h0m0s，默认: 不使用)")
-
-	fmt.Println("\n测速参数:")
-	fmt.Println("  -t        int         延迟测试次数 (默认: 4)")
-	fmt.Println("  -tp       int         测试端口号 (默认: 443)")
-	fmt.Println("  -ts       int         每个CIDR测试的IP数量 (默认: 2)")
-	fmt.Println("  -n        int         并发测试线程数量 (默认: 128)")
-	fmt.Println("\n  注意避免 -t 和 -ts 导致测速量过于庞大！")
-
-	fmt.Println("\n筛选参数:")
-	fmt.Println("  -colo     string      指定数据中心，多个用逗号分隔 (例: HKG,NRT,LAX,SJC)")
-	fmt.Println("  -tl       int         延迟上限 (默认: 500ms)")
-	fmt.Println("  -tll      int         延迟下限 (默认: 0ms)")
-	fmt.Println("  -tlr      float       丢包率上限 (默认: 0.5)")
-	fmt.Println("  -p        string      输出结果数量 (默认: all)")
-
-	fmt.Println("\n输出选项:")
-	fmt.Println("  -nocsv                不生成CSV文件 (默认: 不使用)")
-	fmt.Println("  -useip4   string      生成IPv4列表 (默认: 不使用)")
-	fmt.Println("                      - 使用 all: 输出所有IPv4 CIDR的完整IP列表")
-	fmt.Println("                      - 使用数字 (如9999): 输出指定数量的不重复IPv4")
-	fmt.Println("  -useip6   string      生成IPv6列表 (默认: 不使用)")
-	fmt.Println("                      - 使用数字 (如9999): 输出指定数量的不重复IPv6")
-	fmt.Println("  -iptxt    string      指定IP列表输出文件名 (默认: ip.txt)")
-	fmt.Println("                      - 使用此参数时必须至少使用 -useip4 或 -useip6")
+	printResultsSummary(filteredResults, summaryOptions{
+		tls:      isTLSMode(),
+		download: *dlCount > 0,
+		top:      10,
+	})
 }
 
-// 从URL获取CIDR列表
-func getCIDRFromURL(url string) ([]string, error) {
-	maxRetries := 10
-	retryDelay := 3 * time.Second
-
-	var cidrList []string
-	var lastErr error
-
-	// 创建带超时的HTTP客户端
-	client := &http.Client{
-		Timeout: 3 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-	}
-
-	// 重试逻辑
-	for retry := 0; retry < maxRetries; retry++ {
-		if retry > 0 {
-			fmt.Printf("第 %d 次重试获取CIDR列表...\n", retry)
-			time.Sleep(retryDelay)
-			// 每次重试增加延迟时间
-			retryDelay *= 1
-		}
-
-		resp, err := client.Get(url)
-		if err != nil {
-			lastErr = err
-			// 只显示重试提示，不显示具体错误
-			fmt.Println("获取失败，准备重试...")
-			continue
-		}
+// cfspeed gen: 不进行测速，从CIDR生成随机IP列表
+func runGenCommand(args []string) {
+	shared := scanFlagNames()
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	fs.Usage = printGenHelp
+	addSourceFlags(fs)
+	addIPListFlags(fs)
+	addConfigFlags(fs)
+	parseFlags(fs, args, 0)
+	applyConfig(fs, shared)
 
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			lastErr = fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
-			// 只显示重试提示，不显示具体错误
-			fmt.Println("获取失败，准备重试...")
-			continue
-		}
-
-		// 成功获取，解析CIDR列表
-		cidrList, err = parseCIDRList(resp.Body)
-		resp.Body.Close()
-
-		if err != nil {
-			lastErr = err
-			// 只显示重试提示，不显示具体错误
-			fmt.Println("解析失败，准备重试...")
-			continue
-		}
-
-		// 检查是否成功获取到CIDR
-		if len(cidrList) > 0 {
-			return cidrList, nil
-		}
-
-		lastErr = fmt.Errorf("获取到的CIDR列表为空")
-		// 只显示重试提示，不显示具体错误
-		fmt.Println("获取结果为空，准备重试...")
+	// 检查必要参数
+	if !hasSource() {
+		fmt.Println("错误: 必须至少指定 -url、-f 或 -cidr 其中的一个参数")
+		printGenHelp()
+		os.Exit(2)
+	}
+	if *useIPv4 == "" && *useIPv6 == "" {
+		fmt.Println("错误: 必须至少指定 -useip4 或 -useip6 参数")
+		os.Exit(2)
 	}
 
-	// 修改这里，使用lastErr变量而不是创建新的错误
-	return nil, lastErr
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-// 从文件获取CIDR列表
-func getCIDRFromFile(filename string) ([]string, error) {
-	file, err := os.Open(filename)
+	cidrList, err := loadCIDRList(ctx)
 	if err != nil {
-		return nil, err
+		fmt.Printf("获取CIDR列表失败: %v\n", err)
+		os.Exit(1)
 	}
-	defer file.Close()
-
-	return parseCIDRList(file)
-}
-
-// 解析CIDR列表
-func parseCIDRList(r io.Reader) ([]string, error) {
-	scanner := bufio.NewScanner(r)
-	var cidrList []string
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// 处理不同格式的CIDR
-		if !strings.Contains(line, "/") {
-			// 如果是IPv4
-			if strings.Count(line, ".") == 3 {
-				line = line + "/32"
-			}
-			// 如果是IPv6
-			if strings.Contains(line, ":") {
-				line = line + "/128"
-			}
-		}
+	fmt.Printf("共获取到 %d 个CIDR\n", len(cidrList))
 
-		cidrList = append(cidrList, line)
+	initRand(fs)
+	expandedCIDRs, err := splitCIDRList(cidrList)
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
 	}
+	fmt.Printf("处理后共有 %d 个CIDR\n", len(expandedCIDRs))
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	var results []scan.TestResult
+	for _, cidr := range expandedCIDRs {
+		results = append(results, scan.TestResult{
+			CIDR: cidr,
+		})
 	}
 
-	return cidrList, nil
+	err = scan.GenerateIPFile(results, *useIPv4, *useIPv6, *ipTxtFile, rng, logf)
+	if err != nil {
+		fmt.Printf("生成IP文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("IP列表已写入: %s\n", *ipTxtFile)
 }
 
-// 扩展CIDR列表，将大于/24的IPv4 CIDR拆分为多个/24，将大于/48的IPv6 CIDR拆分为多个/48
-func expandCIDRs(cidrList []string) []string {
-	var expandedList []string
-
-	for _, cidr := range cidrList {
-		// 检查是否是有效的CIDR
-		_, ipNet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			continue
-		}
-
-		// 使用共享 CIDR 字符串
-		sharedCIDR := getSharedCIDR(cidr)
-		expandedList = append(expandedList, sharedCIDR)
-
-		// 判断是IPv4还是IPv6
-		if ipNet.IP.To4() != nil {
-			// IPv4
-			ones, _ := ipNet.Mask.Size()
-			if ones >= 24 {
-				// 已经是/24或更小，直接添加
-				expandedList = append(expandedList, cidr)
-			} else {
-				// 需要拆分为多个/24
-				subCIDRs := expandIPv4CIDR(ipNet, ones)
-				expandedList = append(expandedList, subCIDRs...)
-			}
-		} else {
-			// IPv6
-			ones, _ := ipNet.Mask.Size()
-			if ones >= 48 {
-				// 已经是/48或更小，直接添加
-				expandedList = append(expandedList, cidr)
-			} else {
-				// 需要拆分为多个/48
-				subCIDRs := expandIPv6CIDR(ipNet, ones)
-				expandedList = append(expandedList, subCIDRs...)
-			}
+// cfspeed colo: 查询指定IP所在的数据中心
+func runColoCommand(args []string) {
+	shared := scanFlagNames()
+	fs := flag.NewFlagSet("colo", flag.ExitOnError)
+	fs.Usage = printColoHelp
+	addColoFlags(fs)
+	addConfigFlags(fs)
+	parseFlags(fs, args, -1)
+	applyConfig(fs, shared)
+
+	if fs.NArg() == 0 {
+		fmt.Println("错误: 必须指定要查询的IP")
+		printColoHelp()
+		os.Exit(2)
+	}
+	for _, ip := range fs.Args() {
+		if net.ParseIP(ip) == nil {
+			fmt.Printf("错误: 无效的IP: %s\n", ip)
+			os.Exit(2)
 		}
 	}
-
-	return expandedList
-}
-
-// 将IPv4 CIDR拆分为多个/24
-func expandIPv4CIDR(ipNet *net.IPNet, ones int) []string {
-	// 如果已经是/24或更小，直接返回
-	if ones >= 24 {
-		return []string{ipNet.String()}
+	if err := checkColoFlags(); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(2)
 	}
 
-	// 计算需要拆分的子网数量
-	splitBits := 24 - ones
-	count := 1 << uint(splitBits) // 2^splitBits
-
-	// 将IP地址转换为uint32
-	ip := ipNet.IP.To4()
-	baseIP := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
-
-	// 创建掩码
-	mask := uint32(0xffffffff) << uint(32-ones)
-
-	// 应用掩码获取网络地址
-	baseIP = baseIP & mask
-
-	result := make([]string, 0, count)
-
-	// 生成所有/24子网
-	for i := 0; i < count; i++ {
-		// 计算新的子网地址
-		newIP := baseIP | (uint32(i) << 8)
-
-		// 转换回IP地址格式
-		a := byte((newIP >> 24) & 0xFF)
-		b := byte((newIP >> 16) & 0xFF)
-		c := byte((newIP >> 8) & 0xFF)
-
-		// 创建新的CIDR字符串
-		newCIDR := fmt.Sprintf("%d.%d.%d.0/24", a, b, c)
-		result = append(result, newCIDR)
+	locationMap, err := scan.LoadLocations(scan.LocationOptions{
+		File: *locationsFile,
+		Logf: logf,
+	})
+	if err != nil {
+		fmt.Printf("获取数据中心位置信息失败: %v\n", err)
+		os.Exit(1)
 	}
 
-	return result
-}
-
-// 将IPv6 CIDR拆分为多个/48
-func expandIPv6CIDR(ipNet *net.IPNet, ones int) []string {
-	// 如果已经是/48或更小，直接返回
-	if ones >= 48 {
-		return []string{ipNet.String()}
+	opts := scan.Options{
+		Threads:   len(fs.Args()),
+		Locations: locationMap,
 	}
+	applyColoFlags(&opts)
+	scanner := scan.New(opts)
 
-	// 计算需要拆分的位数
-	splitBits := 48 - ones
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// 限制拆分数量，避免生成过多的子网
-	if splitBits > 16 {
-		splitBits = 16
+	// 同时查询全部IP，按输入顺序输出
+	type coloResult struct {
+		dataCenter, region, city string
+		trace                    scan.TraceInfo
 	}
-
-	// 计算需要拆分的子网数量 (2^splitBits)
-	subnetCount := 1 << uint(splitBits)
-
-	// 获取原始 IP 地址的 16 字节表示
-	ip := ipNet.IP.To16()
-	if ip == nil {
-		return nil
+	results := make([]coloResult, fs.NArg())
+	var wg sync.WaitGroup
+	for i, ip := range fs.Args() {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			r := &results[i]
+			r.dataCenter, r.region, r.city, r.trace = scanner.LookupColo(ctx, ip)
+		}(i, ip)
 	}
+	wg.Wait()
 
-	result := make([]string, 0, subnetCount)
-
-	// 生成所有/48子网
-	for i := 0; i < subnetCount; i++ {
-		// 复制原始 IP 地址
-		newIP := make(net.IP, 16)
-		copy(newIP, ip)
-
-		// 将子网索引 i 设置到 IP 地址的相应位置
-		// IPv6 地址是 16 字节，每个字节 8 位
-		// ones 是网络前缀长度，我们需要修改从 ones 到 48 的位
-
-		// 计算起始字节和位偏移
-		startByte := ones / 8
-		startBit := ones % 8
-
-		// 设置子网索引位
-		remainingBits := splitBits
-		value := i
-
-		// 处理第一个字节（可能需要保留部分位）
-		if startBit > 0 {
-			// 计算第一个字节可以设置的位数
-			bitsInFirstByte := 8 - startBit
-			if bitsInFirstByte > remainingBits {
-				bitsInFirstByte = remainingBits
-			}
-
-			// 创建掩码，保留前 startBit 位
-			mask := byte(0xFF << (8 - startBit))
-
-			// 计算要设置的值
-			valueToSet := byte(value>>(remainingBits-bitsInFirstByte)) << (8 - startBit - bitsInFirstByte)
-
-			// 设置值，保留前 startBit 位
-			newIP[startByte] = (newIP[startByte] & mask) | valueToSet
-
-			remainingBits -= bitsInFirstByte
-			startByte++
-		}
-
-		// 处理完整字节
-		for remainingBits >= 8 {
-			newIP[startByte] = byte(value >> (remainingBits - 8))
-			remainingBits -= 8
-			startByte++
-		}
-
-		// 处理最后一个不完整字节
-		if remainingBits > 0 {
-			valueToSet := byte(value&((1<<remainingBits)-1)) << (8 - remainingBits)
-			newIP[startByte] = valueToSet
-		}
-
-		// 将 48 位之后的所有位清零
-		for j := 6; j < 16; j++ {
-			newIP[j] = 0
-		}
-
-		// 创建新的 /48 CIDR
-		newCIDR := &net.IPNet{
-			IP:   newIP,
-			Mask: net.CIDRMask(48, 128),
+	table := tablewriter.NewWriter(os.Stdout)
+	header := []string{"IP", "数据中心", "区域", "城市"}
+	if isTraceMode() {
+		header = append(header, traceHeader...)
+	}
+	table.SetHeader(header)
+	table.SetBorder(false)
+	for i, ip := range fs.Args() {
+		r := results[i]
+		row := []string{ip, r.dataCenter, r.region, r.city}
+		if isTraceMode() {
+			row = append(row, traceColumns(scan.TestResult{Trace: r.trace})...)
 		}
-		result = append(result, newCIDR.String())
+		table.Append(row)
 	}
-
-	return result
+	table.Render()
 }
 
-// 通用的IPv4地址生成函数 - 新增函数
-func generateRandomIPv4Address(ipNet *net.IPNet) string {
-	// 获取网络地址和掩码
-	ip := ipNet.IP.To4()
-	if ip == nil {
-		return ""
+// cfspeed locations: 列出数据中心位置信息
+func runLocationsCommand(args []string) {
+	fs := flag.NewFlagSet("locations", flag.ExitOnError)
+	fs.Usage = printLocationsHelp
+	locationsFile = fs.String("locations", "", "从本地JSON文件读取数据中心位置信息，不联网获取")
+	region := fs.String("region", "", "只显示指定区域的数据中心，例如 Asia Pacific")
+	parseFlags(fs, args, 0)
+
+	locationMap, err := scan.LoadLocations(scan.LocationOptions{
+		File: *locationsFile,
+		Logf: logf,
+	})
+	if err != nil {
+		fmt.Printf("获取数据中心位置信息失败: %v\n", err)
+		os.Exit(1)
 	}
 
-	mask := ipNet.Mask
-	ones, bits := mask.Size()
-	randomBits := bits - ones
-
-	// 将IP地址转换为uint32
-	baseIP := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
-
-	// 创建掩码
-	netMask := uint32(0xffffffff) << uint(randomBits)
-	networkAddr := baseIP & netMask
-
-	// 计算最大偏移量
-	maxOffset := uint32(1) << uint(randomBits)
-
-	if maxOffset == 1 {
-		// /32，只有一个IP，直接返回
-		return ip.String()
+	var locations []*scan.Location
+	for _, loc := range locationMap {
+		if *region == "" || strings.EqualFold(loc.Region, *region) {
+			locations = append(locations, loc)
+		}
 	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Region != locations[j].Region {
+			return locations[i].Region < locations[j].Region
+		}
+		return locations[i].Iata < locations[j].Iata
+	})
 
-	// 生成随机偏移量
-	randomOffset := uint32(0)
-	if maxOffset > 2 {
-		randomOffset = rand.Uint32() % (maxOffset - 1) // 避开网络地址
-	} else {
-		randomOffset = 1 // /31 的情况，两个地址都可以用
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"数据中心", "区域", "城市"})
+	table.SetBorder(false)
+	for _, loc := range locations {
+		table.Append([]string{loc.Iata, loc.Region, loc.City})
 	}
-
-	// 计算最终IP
-	finalIP := networkAddr | randomOffset
-
-	// 转换回IP地址格式
-	result := net.IPv4(
-		byte(finalIP>>24),
-		byte(finalIP>>16),
-		byte(finalIP>>8),
-		byte(finalIP),
-	)
-
-	return result.String()
+	table.Render()
+	fmt.Printf("\n共 %d 个数据中心\n", len(locations))
 }
 
-// 通用的IPv6地址生成函数 - 新增函数
-func generateRandomIPv6Address(ipNet *net.IPNet) string {
-	// 获取网络地址
-	ip := ipNet.IP.To16()
-	if ip == nil {
-		return ""
-	}
-
-	// 计算可以随机的位数
-	ones, bits := ipNet.Mask.Size()
-	randomBits := bits - ones
+// cfspeed report: 打印 JSON 结果文件的摘要
+func runReportCommand(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = printReportHelp
+	coloFilter := fs.String("colo", "", "只显示指定数据中心的结果，多个用逗号分隔")
+	top := fs.Int("top", 10, "最佳结果表格显示的数量")
+	parseFlags(fs, args, 1)
 
-	// 创建新IP
-	newIP := make(net.IP, 16)
-	copy(newIP, ip)
+	if fs.NArg() != 1 {
+		fmt.Println("错误: 必须指定一个 JSON 结果文件")
+		printReportHelp()
+		os.Exit(2)
+	}
 
-	// 计算需要随机的字节数和位数
-	randomBytes := randomBits / 8
-	remainingBits := randomBits % 8
+	report, err := scan.ReadReport(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("读取结果文件失败: %v\n", err)
+		os.Exit(1)
+	}
 
-	// 完全随机的字节
-	for i := 0; i < 16; i++ {
-		// 只处理需要随机化的字节
-		if i >= 16-randomBytes {
-			// 生成完全随机的字节
-			randValue := byte(rand.Intn(256))
-			// 保留网络前缀部分
-			maskByte := ipNet.Mask[i]
-			newIP[i] = (newIP[i] & maskByte) | (randValue &^ maskByte)
-		}
+	fmt.Printf("工具: %s %s\n", report.Tool, report.Version)
+	fmt.Printf("时间: %s - %s (%s)\n", report.StartTime.Local().Format("2006-01-02 15:04:05"),
+		report.EndTime.Local().Format("2006-01-02 15:04:05"), formatDuration(report.EndTime.Sub(report.StartTime)))
+	if len(report.Sources) > 0 {
+		fmt.Printf("CIDR来源: %s\n", strings.Join(report.Sources, ", "))
 	}
 
-	// 处理剩余的不足一个字节的位
-	if remainingBits > 0 {
-		bytePos := 16 - randomBytes - 1
-		if bytePos >= 0 {
-			// 创建位掩码，只修改需要随机的位
-			bitMask := byte(0xFF >> (8 - remainingBits))
-			// 生成随机值
-			randValue := byte(rand.Intn(1 << remainingBits))
-			// 应用掩码和随机值
-			maskByte := ipNet.Mask[bytePos]
-			// 保留网络前缀，修改主机部分
-			newIP[bytePos] = (newIP[bytePos] & maskByte) | (randValue & bitMask & (^maskByte))
+	results := report.Results
+	if colos := splitList(*coloFilter); len(colos) > 0 {
+		results = nil
+		for _, result := range report.Results {
+			for _, colo := range colos {
+				if strings.EqualFold(result.DataCenter, colo) {
+					results = append(results, result)
+					break
+				}
+			}
 		}
 	}
+	fmt.Printf("结果数量: %d\n", len(results))
 
-	// 检查生成的IP是否为全零地址
-	isZero := true
-	for _, b := range newIP {
-		if b != 0 {
-			isZero = false
+	// 根据测速时的参数和结果决定显示的列
+	download := false
+	for _, result := range results {
+		if result.DownloadSpeed > 0 {
+			download = true
 			break
 		}
 	}
-
-	// 如果是全零地址，重新生成
-	if isZero {
-		// 简单地将最后一个字节设为1，确保不是全零地址
-		newIP[15] = 1
-	}
-
-	return newIP.String()
+	printResultsSummary(results, summaryOptions{
+		tls:      report.Flags["mode"] == scan.ModeTLS,
+		download: download,
+		top:      *top,
+	})
 }
 
-// 获取Cloudflare数据中心位置信息
-func getLocationMap() (map[string]*location, error) {
-	// 设置最大重试次数
-	maxRetries := 5
-	retryDelay := 2 * time.Second
-
-	var lastErr error
-
-	for retry := 0; retry < maxRetries; retry++ {
-		if retry > 0 {
-			time.Sleep(retryDelay)
-		}
-
-		// 创建带超时的客户端
-		client := &http.Client{
-			Timeout: 3 * time.Second,
-		}
-
-		resp, err := client.Get("https://speed.cloudflare.com/locations")
-		if err != nil {
-			lastErr = fmt.Errorf("无法获取 locations.json: %v", err)
-			continue // 重试
-		}
-
-		// 确保响应体被关闭
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			lastErr = fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
-			continue // 重试
-		}
-
-		// 读取整个响应体到内存
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			lastErr = fmt.Errorf("读取响应体失败: %v", err)
-			continue // 重试
-		}
-
-		// 检查响应体是否为空
-		if len(body) == 0 {
-			lastErr = fmt.Errorf("获取到的响应体为空")
-			continue // 重试
-		}
-
-		// 解析JSON
-		var locations []location
-		if err := json.Unmarshal(body, &locations); err != nil {
-			lastErr = fmt.Errorf("无法解析JSON: %v", err)
-			continue // 重试
-		}
-
-		// 检查解析后的数据是否为空
-		if len(locations) == 0 {
-			lastErr = fmt.Errorf("解析后的数据中心列表为空")
-			continue // 重试
-		}
-
-		// 构造 location 映射，key 为数据中心代码，使用指针
-		locationMap := make(map[string]*location)
-		for i := range locations {
-			locationMap[locations[i].Iata] = &locations[i]
-		}
-
-		// 成功获取数据
-		return locationMap, nil
-	}
+// ----------------------- 功能模块 -----------------------
 
-	// 所有重试都失败
-	return nil, fmt.Errorf("在%d次尝试后仍然失败: %v", maxRetries, lastErr)
+// 输出 scan 包的提示信息
+func logf(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
 }
 
-// 时间格式化
-func formatDuration(d time.Duration) string {
-	d = d.Round(time.Second)
-	h := d / time.Hour
-	d -= h * time.Hour
-	m := d / time.Minute
-	d -= m * time.Minute
-	s := d / time.Second
-
-	if h > 0 {
-		return fmt.Sprintf("%d时%d分%d秒", h, m, s)
-	}
-	if m > 0 {
-		return fmt.Sprintf("%d分%d秒", m, s)
-	}
-	return fmt.Sprintf("%d秒", s)
+// 是否指定了CIDR来源
+func hasSource() bool {
+	return *urlFlag != "" || *fileFlag != "" || *cidrFlag != ""
 }
 
-// 测试IP性能
-func testIPs(cidrGroups []CIDRGroup, port, testCount, maxThreads, ipPerCIDR int, locationMap map[string]*location,
-	coloFlag *string, minLatency, maxLatency *int, maxLossRate *float64, showAll *bool) []CIDRGroup {
-	// 初始化并发控制
-	if globalSem == nil {
-		globalSem = semaphore.NewWeighted(int64(maxThreads))
+// 按 -cidr、-url、-f 的优先级获取CIDR列表，去除重复和重叠的CIDR，
+// 并去掉 -exclude 和 -exclude-file 指定的地址
+func loadCIDRList(ctx context.Context) ([]string, error) {
+	cidrList, err := readCIDRSource(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// 添加内存管理相关变量
-	var processedGroupCount int32
-	var mutex sync.Mutex
-	var wg sync.WaitGroup
-
-	resultChan := make(chan TestResult, maxThreads)
-
-	// CIDR 完成计数器
-	cidrIPCounts := make(map[string]struct {
-		total   int
-		current int
-	})
-
-	// 初始化每个 CIDR 的计数
-	for _, group := range cidrGroups {
-		cidrIPCounts[group.CIDR] = struct {
-			total   int
-			current int
-		}{
-			total: ipPerCIDR,
-		}
+	// 随机选取子网时按每个输入的CIDR选取，不合并相邻的CIDR
+	normalize := scan.NormalizeCIDRs
+	if *sampleGroups > 0 {
+		normalize = scan.DedupeCIDRs
 	}
-
-	// 计算总IP数量
-	totalIPs := len(cidrGroups) * ipPerCIDR
-
-	// CIDR数据中心信息缓存
-	type cidrCache struct {
-		sync.RWMutex
-		dataCenter *string
-		region     *string
-		city       *string
-		found      bool
+	cidrList, stats := normalize(cidrList)
+	if stats.Removed() > 0 {
+		fmt.Printf("去除 %d 个重复、%d 个被包含、%d 个无效的CIDR，合并 %d 个相邻的CIDR，剩余 %d 个CIDR\n",
+			stats.Duplicates, stats.Overlaps, stats.Invalid, stats.Merged, len(cidrList))
 	}
-	cidrColoMap := make(map[string]*cidrCache)
 
-	// 初始化CIDR缓存
-	for _, group := range cidrGroups {
-		cidrColoMap[group.CIDR] = &cidrCache{}
+	excludes := splitList(*excludeFlag)
+	if *excludeFile != "" {
+		list, err := scan.ReadCIDRFile(*excludeFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取排除列表失败: %v", err)
+		}
+		excludes = append(excludes, list...)
 	}
-
-	// 计数器
-	var (
-		processedCount  int32
-		tcpSuccessCount int32
-	)
-
-	startTime := time.Now()
-
-	// 创建进度条
-	tmpl := `{{counters . }} {{ bar . "[" "=" (cycle . "↖" "↗" "↘" "↙") "_" "]"}} {{string . "elapsed"}}` // 使用等宽块字符
-	bar := pb.ProgressBarTemplate(tmpl).Start(totalIPs)
-	bar.Set("total", fmt.Sprintf("%d", totalIPs))
-	bar.Set("current", "0")
-	bar.Start()
-
-	// 初始化每个 CIDR 组的 Data 字段
-	for i := range cidrGroups {
-		cidrGroups[i].Data = testDataPool.Get().(*CIDRTestData)
+	if len(excludes) == 0 {
+		return cidrList, nil
 	}
 
-	// 启动结果处理协程
-	go func() {
-		defer close(resultChan)
-		for result := range resultChan {
-			mutex.Lock()
-
-			counts := cidrIPCounts[result.CIDR]
-
-			// 添加结果到临时存储
-			for i := range cidrGroups {
-				if cidrGroups[i].CIDR == result.CIDR {
-					cidrGroups[i].Data.Results = append(cidrGroups[i].Data.Results, result)
-
-					// 使用实际结果数量判断是否完成
-					if len(cidrGroups[i].Data.Results) == counts.total {
-						// 调用 finalize 方法处理结果
-						cidrGroups[i].finalize()
-
-						// 检查结果是否符合过滤条件
-						if !shouldIncludeResult(TestResult{
-							CIDR:       cidrGroups[i].Result.CIDR,
-							DataCenter: cidrGroups[i].Result.DataCenter,
-							Region:     cidrGroups[i].Result.Region,
-							City:       cidrGroups[i].Result.City,
-							AvgLatency: cidrGroups[i].Result.AvgLatency,
-							LossRate:   cidrGroups[i].Result.LossRate,
-						}, coloFlag, minLatency, maxLatency, maxLossRate, showAll) {
-							cidrGroups[i].Result = nil
-						}
-
-						// 增加已处理组计数
-						atomic.AddInt32(&processedGroupCount, 1)
-					}
-					break
-				}
-			}
-			mutex.Unlock()
-		}
-	}()
-
-	// 创建工作池
-	for i := 0; i < maxThreads; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for {
-				// 获取一个未完成的CIDR
-				mutex.Lock()
-				var currentGroup *CIDRGroup
-				for i := range cidrGroups {
-					counts := cidrIPCounts[cidrGroups[i].CIDR]
-					if counts.current < counts.total {
-						currentGroup = &cidrGroups[i]
-						counts.current++ // 提前增加计数
-						cidrIPCounts[cidrGroups[i].CIDR] = counts
-						break
-					}
-				}
-				if currentGroup == nil {
-					mutex.Unlock()
-					return // 所有CIDR都已处理完成
-				}
-				mutex.Unlock()
-
-				// 使用对象池
-				data := testDataPool.Get().(*CIDRTestData)
-				defer testDataPool.Put(data)
-
-				// 生成并测试一个IP
-				_, ipNet, err := net.ParseCIDR(currentGroup.CIDR)
-				if err != nil {
-					continue
-				}
-
-				// 生成随机IP
-				var ip string
-				if ipNet.IP.To4() != nil {
-					ip = generateRandomIPv4Address(ipNet)
-				} else {
-					ip = generateRandomIPv6Address(ipNet)
-				}
-
-				resultObj := testResultPool.Get().(*TestResult)
-				resultObj.Clear() // 清空对象
-
-				// 设置基本信息
-				resultObj.IP = ip
-				resultObj.CIDR = currentGroup.CIDR
-
-				// 执行TCP测试
-				localSuccessCount := 0
-				totalLatency := time.Duration(0)
-				for i := 0; i < testCount; i++ {
-					start := time.Now()
-					conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), time.Second)
-					if err != nil {
-						continue
-					}
-					latency := time.Since(start)
-					conn.Close()
-
-					localSuccessCount++
-					totalLatency += latency
-				}
+	cidrList, changed, err := scan.ExcludeCIDRs(cidrList, excludes)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("排除 %d 个CIDR或IP，影响 %d 个CIDR，剩余 %d 个CIDR\n", len(excludes), changed, len(cidrList))
+	return cidrList, nil
+}
 
-				if localSuccessCount > 0 {
-					// TCP测试成功
-					avgLatency := totalLatency / time.Duration(localSuccessCount)
-					resultObj.AvgLatency = int(avgLatency.Milliseconds())
-					resultObj.LossRate = float64(testCount-localSuccessCount) / float64(testCount)
-
-					// 检查CIDR是否已有数据中心信息
-					cache := cidrColoMap[currentGroup.CIDR]
-					cache.RLock()
-					if cache.found {
-						resultObj.DataCenter = *cache.dataCenter
-						resultObj.Region = *cache.region
-						resultObj.City = *cache.city
-						cache.RUnlock()
-					} else {
-						cache.RUnlock()
-						dataCenter, region, city := getDataCenterInfo(ip, locationMap)
-						if dataCenter != "Unknown" {
-							cache.Lock()
-							if !cache.found {
-								// 查找locationMap中是否有该数据中心
-								if loc, ok := locationMap[dataCenter]; ok {
-									// 使用指针指向locationMap中的数据
-									cache.dataCenter = &dataCenter
-									cache.region = &loc.Region
-									cache.city = &loc.City
-								} else {
-									// 如果locationMap中没有，则创建新的字符串
-									dcCopy := dataCenter
-									regionCopy := region
-									cityCopy := city
-									cache.dataCenter = &dcCopy
-									cache.region = &regionCopy
-									cache.city = &cityCopy
-								}
-								cache.found = true
-							}
-							cache.Unlock()
-						}
-						resultObj.DataCenter = dataCenter
-						resultObj.Region = region
-						resultObj.City = city
-					}
-
-					// 发送结果到结果通道
-					resultChan <- *resultObj // 发送副本而不是指针
-					atomic.AddInt32(&tcpSuccessCount, 1)
-				}
+// 读取 -cidr、-url 或 -f 指定的CIDR列表
+func readCIDRSource(ctx context.Context) ([]string, error) {
+	if *cidrFlag != "" {
+		// 处理手动指定的CIDR
+		cidrList := strings.Split(*cidrFlag, ",")
+		fmt.Printf("从命令行参数获取 %d 个CIDR\n", len(cidrList))
+		return cidrList, nil
+	}
+	if *urlFlag != "" {
+		fmt.Printf("从URL获取CIDR列表: %s\n", *urlFlag)
+		return scan.FetchCIDRList(ctx, *urlFlag, scan.FetchOptions{
+			Timeout:    *urlTimeout,
+			Retries:    *urlRetries,
+			RetryDelay: *urlRetryDelay,
+			Logf:       logf,
+		})
+	}
+	fmt.Printf("从文件获取CIDR列表: %s\n", *fileFlag)
+	return scan.ReadCIDRFile(*fileFlag)
+}
 
-				// 将对象放回池中
-				testResultPool.Put(resultObj)
+// 影响测速结果的参数，保存在断点文件中，继续测速时必须一致
+func checkpointParams() map[string]string {
+	params := map[string]string{
+		"t":             strconv.Itoa(*testCount),
+		"ts":            strconv.Itoa(*ipPerCIDR),
+		"tp":            strconv.Itoa(*portFlag),
+		"mode":          *modeFlag,
+		"split4":        *split4Flag,
+		"split6":        *split6Flag,
+		"sample-groups": strconv.Itoa(*sampleGroups),
+	}
+	if *modeFlag == scan.ModeTLS {
+		params["sni"] = *sniFlag
+	}
+	// 随机选取子网时，种子决定测试哪些CIDR
+	if *sampleGroups > 0 {
+		params["seed"] = strconv.FormatInt(*seedFlag, 10)
+	}
+	return params
+}
 
-				// 更新进度
-				current := atomic.AddInt32(&processedCount, 1)
-				elapsed := time.Since(startTime)
-				bar.Set("current", fmt.Sprintf("%d", current))
-				bar.Set("elapsed", formatDuration(elapsed))
-				bar.SetCurrent(int64(current))
-			}
-		}()
+// 按 -split4、-split6 和 -max-groups 将CIDR列表拆分为测试组
+func splitCIDRList(cidrList []string) ([]string, error) {
+	if *sampleGroups < 0 {
+		return nil, fmt.Errorf("无效的 -sample-groups: %d", *sampleGroups)
+	}
+	opts := scan.SplitOptions{MaxGroups: *maxGroups, SampleGroups: *sampleGroups, Rand: rng}
+	var err error
+	if opts.IPv4Bits, err = parseSplitBits(*split4Flag, 32); err != nil {
+		return nil, fmt.Errorf("无效的 -split4: %v", err)
+	}
+	if opts.IPv6Bits, err = parseSplitBits(*split6Flag, 128); err != nil {
+		return nil, fmt.Errorf("无效的 -split6: %v", err)
 	}
 
-	// 等待所有工作完成
-	wg.Wait()
-
-	// 先完成进度条
-	bar.Finish()
-
-	// 计算TCP测试成功率
-	tcpSuccessRate := float64(tcpSuccessCount) / float64(totalIPs) * 100
-	fmt.Printf("TCP测试完成，成功率: %.2f%% (%d/%d)\n", tcpSuccessRate, tcpSuccessCount, totalIPs)
-
-	// 过滤结果时只保留有最终结果的组
-	var filteredGroups []CIDRGroup
-	for _, group := range cidrGroups {
-		// 只保留有Result且不为nil的组
-		if group.Result != nil {
-			filteredGroups = append(filteredGroups, group)
-		} else if group.Data != nil {
-			// 对于没有最终结果的组，确保其Data被放回对象池
-			group.Data.Results = group.Data.Results[:0]
-			group.Data.IPs = group.Data.IPs[:0]
-			testDataPool.Put(group.Data)
-		}
+	expandedCIDRs, err := scan.SplitCIDRs(cidrList, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%v，请减小 -split4、-split6 的前缀长度，使用 -sample-groups 随机选取子网，或调整 -max-groups", err)
 	}
+	return expandedCIDRs, nil
+}
 
-	return filteredGroups
+// 根据 -seed 创建随机数生成器，未指定时使用当前时间作为种子，并输出实际使用的种子
+func initRand(fs *flag.FlagSet) {
+	// 通过命令行或配置文件指定的种子都会被使用，包括 0
+	if !isFlagSet(fs, "seed") {
+		*seedFlag = time.Now().UnixNano()
+	}
+	rng = rand.New(rand.NewSource(*seedFlag))
+	fmt.Printf("随机种子: %d\n", *seedFlag)
 }
 
-// 获取数据中心信息
-func getDataCenterInfo(ip string, locationMap map[string]*location) (string, string, string) {
+// 解析拆分后的前缀长度，none 表示不拆分
+func parseSplitBits(value string, maxBits int) (int, error) {
+	if strings.EqualFold(value, "none") {
+		return 0, nil
+	}
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 1 || bits > maxBits {
+		return 0, fmt.Errorf("%s (应为 1-%d 或 none)", value, maxBits)
+	}
+	return bits, nil
+}
 
-	// 使用全局通道控制并发
-	ctx := context.Background()
-	if err := globalSem.Acquire(ctx, 1); err != nil {
-		return "Unknown", "", ""
+// 检查数据中心查询参数
+func checkColoFlags() error {
+	if *coloMode != scan.ColoModeRay && *coloMode != scan.ColoModeTrace {
+		return fmt.Errorf("不支持的数据中心查询方式: %s", *coloMode)
 	}
-	defer globalSem.Release(1)
+	if *coloScheme != "http" && *coloScheme != "https" {
+		return fmt.Errorf("不支持的数据中心查询协议: %s", *coloScheme)
+	}
+	if *coloPort < 0 || *coloPort > 65535 {
+		return fmt.Errorf("无效的数据中心查询端口: %d", *coloPort)
+	}
+	return nil
+}
 
-	maxRetries := 2                      // 重试次数
-	retryDelay := 800 * time.Millisecond // 添加重试延迟
+// 将数据中心查询参数写入配置
+func applyColoFlags(opts *scan.Options) {
+	opts.ColoMode = *coloMode
+	opts.ColoScheme = *coloScheme
+	opts.ColoPort = *coloPort
+	opts.ColoHost = *coloHost
+	opts.ColoSNI = *coloSNI
+	opts.ColoPath = *coloPath
+	opts.ColoTimeout = *coloTimeout
+	opts.ColoRetries = *coloRetries
+	opts.ColoRetryDelay = *coloRetryDelay
+}
 
-	// 使用共享的 Transport 对象
-	transport := &http.Transport{
-		DisableKeepAlives: true,
-		IdleConnTimeout:   1500 * time.Millisecond, // 超时时间
-		MaxIdleConns:      100,
-		MaxConnsPerHost:   10,
-	}
+// 配置文件格式，顶层为默认参数，profiles 下为命名配置，使用时覆盖顶层的同名参数
+type configFileData struct {
+	Flags    map[string]interface{}            `yaml:",inline"`
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
 
-	client := &http.Client{
-		Timeout:   1000 * time.Millisecond, // 超时时间
-		Transport: transport,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+// 读取配置文件并设置 fs 中命令行未明确指定的参数，
+// fs 中没有但在 shared 中的参数忽略，两者都没有的参数报错
+func loadConfig(fs *flag.FlagSet, filename, profile string, shared map[string]bool) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
 	}
 
-	// 确保资源被释放
-	defer transport.CloseIdleConnections()
+	var config configFileData
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
 
-	for retry := 0; retry <= maxRetries; retry++ {
-		// 添加重试延迟，第一次尝试不延迟
-		if retry > 0 {
-			time.Sleep(retryDelay)
+	values := make(map[string]interface{})
+	for name, value := range config.Flags {
+		values[name] = value
+	}
+	if profile != "" {
+		profileValues, ok := config.Profiles[profile]
+		if !ok {
+			return fmt.Errorf("配置文件中没有名为 %s 的配置", profile)
 		}
-		hostIP := ip
-		if !strings.Contains(ip, ".") {
-			hostIP = "[" + ip + "]"
+		for name, value := range profileValues {
+			values[name] = value
 		}
+	}
 
-		req, err := http.NewRequest("HEAD", "http://cloudflare.com", nil)
-		if err != nil {
-			continue
-		}
+	// 命令行中明确指定的参数不被配置文件覆盖
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
 
-		req.Host = "cloudflare.com"
-		req.URL.Host = hostIP
-		req.Close = true
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			continue
+	for _, name := range names {
+		if name == "config" || name == "profile" {
+			return fmt.Errorf("配置文件中不能设置 %s", name)
 		}
-
-		cfRay := resp.Header.Get("Cf-Ray")
-		resp.Body.Close()
-
-		if cfRay == "" {
-			continue
+		if fs.Lookup(name) == nil {
+			if shared[name] {
+				continue
+			}
+			return fmt.Errorf("未知参数: %s", name)
 		}
-
-		lastDashIndex := strings.LastIndex(cfRay, "-")
-		if lastDashIndex == -1 {
+		if explicit[name] {
 			continue
 		}
-
-		dataCenter := cfRay[lastDashIndex+1:]
-		if dataCenter != "" {
-			loc, ok := locationMap[dataCenter]
-			if ok {
-				return dataCenter, loc.Region, loc.City
-			}
-			return dataCenter, "", ""
+		if err := fs.Set(name, configValue(values[name])); err != nil {
+			return fmt.Errorf("参数 %s: %v", name, err)
 		}
 	}
+	return nil
+}
 
-	return "Unknown", "", ""
+// 将配置值转换为命令行参数的格式，列表用逗号连接
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
 }
 
-// 生成IP文件
-func generateIPFile(results []TestResult, ipv4Mode, ipv6Mode, filename string) error {
-	// 检查是否至少指定了一种IP类型
-	if ipv4Mode == "" && ipv6Mode == "" {
-		return fmt.Errorf("必须至少指定 -useip4 或 -useip6 参数")
+// 拆分逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
+}
 
-	var ipList []string
+// 是否使用 tls 探测方式
+func isTLSMode() bool {
+	return *modeFlag == scan.ModeTLS
+}
 
-	// 检查是否有IPv4和IPv6的CIDR
-	hasIPv4CIDR := false
-	hasIPv6CIDR := false
+// 是否使用数据中心缓存
+func useColoCache() bool {
+	return *coloMaxAge > 0
+}
 
-	// 根据需要检查的IP类型进行判断
-	needCheckIPv4 := ipv4Mode != ""
-	needCheckIPv6 := ipv6Mode != ""
+// 是否通过 /cdn-cgi/trace 查询数据中心
+func isTraceMode() bool {
+	return *coloMode == scan.ColoModeTrace
+}
 
-	for _, result := range results {
-		_, ipNet, err := net.ParseCIDR(result.CIDR)
-		if err != nil {
-			continue
-		}
+// 测试进度条
+type progressBar struct {
+	bar       *pb.ProgressBar
+	startTime time.Time
+}
 
-		// 分别判断IPv4和IPv6
-		if needCheckIPv4 && !hasIPv4CIDR && ipNet.IP.To4() != nil {
-			hasIPv4CIDR = true
-		}
+func newProgressBar() *progressBar {
+	return &progressBar{startTime: time.Now()}
+}
 
-		if needCheckIPv6 && !hasIPv6CIDR && ipNet.IP.To4() == nil && ipNet.IP.To16() != nil {
-			hasIPv6CIDR = true
-		}
+// 每轮测试开始时创建进度条，之后更新进度
+func (p *progressBar) update(done, total int) {
+	if done == 0 {
+		p.finish()
+		p.bar = nil
+	}
+	if p.bar == nil {
+		tmpl := `{{counters . }} {{ bar . "[" "=" (cycle . "↖" "↗" "↘" "↙") "_" "]"}} {{string . "elapsed"}}` // 使用等宽块字符
+		p.bar = pb.ProgressBarTemplate(tmpl).Start(total)
+		p.bar.Set("total", fmt.Sprintf("%d", total))
+		p.bar.Set("current", "0")
+		p.bar.Start()
+	}
+	elapsed := time.Since(p.startTime)
+	p.bar.Set("current", fmt.Sprintf("%d", done))
+	p.bar.Set("elapsed", formatDuration(elapsed))
+	p.bar.SetCurrent(int64(done))
+}
 
-		// 如果需要检查的类型都已找到，就可以提前结束检查
-		if (!needCheckIPv4 || hasIPv4CIDR) && (!needCheckIPv6 || hasIPv6CIDR) {
-			break
-		}
+func (p *progressBar) finish() {
+	if p.bar != nil {
+		p.bar.Finish()
 	}
+}
 
-	// 处理 IPv4
-	if ipv4Mode != "" && hasIPv4CIDR {
-		ipv4Count := 0
-		ipv4Limit := 1000000 // 设置IPv4上限为100万
+// 打印子命令列表
+func printUsage() {
+	fmt.Println("用法: cfspeed <命令> [参数]")
+	fmt.Println("\n命令:")
+	fmt.Println("  scan                  对CIDR进行延迟测速和数据中心查询，省略命令时默认使用")
+	fmt.Println("  gen                   不进行测速，从CIDR生成随机IP列表")
+	fmt.Println("  colo      <IP>...     查询指定IP所在的数据中心")
+	fmt.Println("  locations             列出Cloudflare数据中心位置信息")
+	fmt.Println("  report    <文件>      打印 -format json 输出的结果文件的摘要")
+	fmt.Println("\n使用 cfspeed <命令> -h 查看各命令的参数")
+	fmt.Println("旧版本的 -notest 参数已弃用，省略命令时使用 -notest 等同于 cfspeed gen")
+}
 
-		if ipv4Mode == "all" {
-			// 遍历每个CIDR生成所有IP
-			for _, result := range results {
-				_, ipNet, err := net.ParseCIDR(result.CIDR)
-				if err != nil || ipNet.IP.To4() == nil {
-					continue // 跳过非IPv4
-				}
+// 打印CIDR来源参数，scan 和 gen 共用
+func printSourceHelp() {
+	fmt.Println("\nCIDR来源:")
+	fmt.Println("  -url      string      CIDR列表链接")
+	fmt.Println("  -cidr     string      手动指定CIDR，多个用逗号分隔 (例: 104.16.0.0/13,2606:4700::/36)")
+	fmt.Println("  -f        string      CIDR列表文件 (当未设置-url时使用)")
+	fmt.Println("  -exclude  string      排除的CIDR或IP，多个用逗号分隔 (例: 104.16.0.0/24,104.17.0.1)")
+	fmt.Println("                      - 部分重叠的CIDR会拆分为不包含排除地址的子网")
+	fmt.Println("  -exclude-file string  排除的CIDR列表文件，格式与 -f 相同")
+	fmt.Println("  -split4   string      IPv4 CIDR拆分后的前缀长度，none 表示不拆分 (默认: 24)")
+	fmt.Println("  -split6   string      IPv6 CIDR拆分后的前缀长度，none 表示不拆分 (默认: 48)")
+	fmt.Println("  -max-groups int       拆分后的CIDR数量上限，超过时报错，0 表示不限制 (默认: 1048576)")
+	fmt.Println("  -sample-groups int    每个CIDR拆分后随机选取的子网数量 (默认: 0，使用全部子网)")
+	fmt.Println("                      - 按每个输入的CIDR选取，相邻的CIDR不会合并；被 -exclude 拆开的CIDR按拆开后的每一段选取")
+	fmt.Println("  -seed     int         随机种子，相同的种子和参数会选取相同的子网和IP (默认: 使用当前时间)")
+	fmt.Println("                      - 使用 -resume 继续随机选取子网的测速时，请指定与上次相同的种子")
+	fmt.Println("  -utimeout duration    获取CIDR链接超时 (默认: 3s)")
+	fmt.Println("  -uretry   int         获取CIDR链接最大尝试次数 (默认: 10)")
+	fmt.Println("  -udelay   duration    获取CIDR链接重试间隔 (默认: 3s)")
+}
 
-				// 获取掩码大小
-				ones, _ := ipNet.Mask.Size()
-				randomBits := 32 - ones
-
-				// 计算该CIDR包含的IP数量
-				totalIPs := 1 << uint(randomBits)
-
-				// 生成该CIDR下的所有IP
-				for i := 0; i < totalIPs && ipv4Count < ipv4Limit; i++ {
-					// 创建新IP
-					newIP := make(net.IP, 4)
-					copy(newIP, ipNet.IP.To4())
-
-					// 应用值到IP
-					for j := 0; j < 4; j++ {
-						shift := uint(8 * (3 - j))
-						if shift < uint(randomBits) {
-							bitValue := byte((i >> shift) & 0xFF)
-							maskByte := ipNet.Mask[j]
-							newIP[j] = (newIP[j] & maskByte) | (bitValue &^ maskByte)
-						}
-					}
-
-					ipStr := newIP.String()
-					if ipStr != "" {
-						ipList = append(ipList, ipStr)
-						ipv4Count++
-					}
-				}
+// 打印IP列表参数，scan 和 gen 共用
+func printIPListHelp() {
+	fmt.Println("  -useip4   string      生成IPv4列表 (默认: 不使用)")
+	fmt.Println("                      - 使用 all: 输出所有IPv4 CIDR的完整IP列表")
+	fmt.Println("                      - 使用数字 (如9999): 输出指定数量的不重复IPv4")
+	fmt.Println("  -useip6   string      生成IPv6列表 (默认: 不使用)")
+	fmt.Println("                      - 使用数字 (如9999): 输出指定数量的不重复IPv6")
+	fmt.Println("  -iptxt    string      指定IP列表输出文件名 (默认: ip.txt)")
+}
 
-				// 检查是否达到上限
-				if ipv4Count >= ipv4Limit {
-					fmt.Printf("已达到IPv4生成上限 %d 个\n", ipv4Limit)
-					break
-				}
-			}
-		} else if count, err := strconv.Atoi(ipv4Mode); err == nil && count > 0 {
-			targetCount := count
-			if targetCount > ipv4Limit {
-				targetCount = ipv4Limit
-				fmt.Printf("IPv4生成数量已限制为 %d 个\n", ipv4Limit)
-			}
+// 打印数据中心查询参数，scan 和 colo 共用
+func printColoFlagsHelp() {
+	fmt.Println("\n数据中心查询参数:")
+	fmt.Println("  -locations string     从本地JSON文件读取数据中心位置信息 (默认: 联网获取)")
+	fmt.Println("                      - 格式与 https://speed.cloudflare.com/locations 相同")
+	fmt.Println("                      - 联网获取的数据缓存7天，获取失败时使用缓存或内置数据")
+	fmt.Println("  -cmode    string      数据中心查询方式 (默认: ray)")
+	fmt.Println("                      - ray: 发送 HEAD 请求，读取 Cf-Ray 响应头")
+	fmt.Println("                      - trace: 请求 /cdn-cgi/trace，同时记录出口IP、出口位置、HTTP和TLS版本")
+	fmt.Println("  -cscheme  string      数据中心查询使用的协议，可选: http, https (默认: http)")
+	fmt.Println("  -cport    int         数据中心查询使用的端口 (默认: http 为 80，https 为 443)")
+	fmt.Println("  -chost    string      数据中心查询请求的 Host (默认: cloudflare.com)")
+	fmt.Println("  -csni     string      数据中心查询使用的SNI，仅 https (默认: 与 -chost 相同)")
+	fmt.Println("  -cpath    string      数据中心查询请求的路径 (默认: ray 为 /，trace 为 /cdn-cgi/trace)")
+	fmt.Println("  -ctimeout duration    数据中心查询超时 (默认: 1s)")
+	fmt.Println("  -cretry   int         数据中心查询重试次数 (默认: 2)")
+	fmt.Println("  -cdelay   duration    数据中心查询重试间隔 (默认: 800ms)")
+	fmt.Println("\n  IP段不提供 cloudflare.com 或网络屏蔽80端口时，可改用该IP段上可用的域名和端口，")
+	fmt.Println("  例如 -cscheme https -chost www.example.com")
+}
 
-			// 准备CIDR列表和计算总IP数量
-			type cidrInfo struct {
-				ipNet   *net.IPNet
-				ipCount int
-			}
+// 打印 scan 命令的帮助信息
+func printScanHelp() {
+	fmt.Println("用法: cfspeed [scan] [参数]")
+	printSourceHelp()
 
-			var cidrList []cidrInfo
-			totalAvailableIPs := 0
+	fmt.Println("\n基本参数:")
+	fmt.Println("  -o        string      结果文件名 (默认: IP_Speed.csv)")
+	fmt.Println("  -format   string      结果文件格式，多个用逗号分隔 (默认: csv)")
+	fmt.Println("                      - 可选: csv, json, ndjson")
+	fmt.Println("                      - CSV 写入 -o 指定的文件，其他格式按格式替换扩展名 (例: IP_Speed.json)")
+	fmt.Println("                      - 明确指定 -o 且只输出一种格式时写入 -o 指定的文件")
+	fmt.Println("                      - json 包含开始时间、参数、CIDR来源和版本号等元数据")
+	fmt.Println("  -h                    显示帮助信息")
+	printConfigHelp()
+	fmt.Println("  -showall              使用后显示所有结果，包括未查询到数据中心的结果")
+	fmt.Println("  -timeout  string      程序执行超时退出 (例: 5h0m0s，默认: 不使用)")
+	fmt.Println("  -checkpoint string    断点文件，测速过程中定期保存已完成的CIDR (默认: 不保存断点)")
+	fmt.Println("                      - 测速全部完成后自动删除；文件已存在时需使用 -resume 继续或先删除")
+	fmt.Println("  -resume               从 -checkpoint 指定的断点文件继续上次未完成的测速，跳过已完成的CIDR")
+	fmt.Println("                      - -t、-ts、-tp、-mode、-sni、-split4、-split6、-sample-groups 和随机选取子网时的")
+	fmt.Println("                        -seed 必须与断点一致")
 
-			// 初始化CIDR信息并计算总IP数量
-			for _, result := range results {
-				_, ipNet, err := net.ParseCIDR(result.CIDR)
-				if err != nil || ipNet.IP.To4() == nil {
-					continue // 跳过非IPv4
-				}
+	fmt.Println("\n测速参数:")
+	fmt.Println("  -t        int         延迟测试次数 (默认: 4)")
+	fmt.Println("  -tp       int         测试端口号 (默认: 443)")
+	fmt.Println("  -mode     string      探测方式 (默认: tcp)")
+	fmt.Println("                      - tcp: 测量TCP连接耗时")
+	fmt.Println("                      - tls: 分别测量TCP连接和TLS握手耗时，延迟筛选和排序使用两者之和")
+	fmt.Println("  -sni      string      tls 模式使用的SNI (默认: speed.cloudflare.com)")
+	fmt.Println("  -ts       int         每个CIDR测试的不重复IP数量，CIDR中的IP不足时测试全部IP (默认: 2)")
+	fmt.Println("  -n        int         并发测试线程数量 (默认: 128)")
+	fmt.Println("  -adaptive             自适应测速 (默认: 不使用)")
+	fmt.Println("                      - 先对每个CIDR的1个IP探测1次，丢弃超出 -tl 或 -tlr 的CIDR")
+	fmt.Println("                      - 再按 -ts 和 -t 对剩余CIDR进行完整测试")
+	fmt.Println("  -ptimeout duration    单次探测超时 (默认: 1s)")
+	fmt.Println("  -pinterval duration   同一IP两次探测之间的间隔 (默认: 0)")
+	fmt.Println("\n  注意避免 -t 和 -ts 导致测速量过于庞大！大量CIDR时可使用 -adaptive")
+	fmt.Println("  高延迟网络可适当增大 -ptimeout，避免可用IP因超时被丢弃")
+
+	printColoFlagsHelp()
+	fmt.Println("  -cmaxage  duration    数据中心缓存有效期，有效期内的CIDR不再查询 (默认: 0，不使用缓存)")
+	fmt.Println("                      - 结果中的数据中心来源为 cached (缓存) 或 live (实时查询)")
+	fmt.Println("  -ccache   string      数据中心缓存文件 (默认: 用户缓存目录下的 cfspeed/colo.json)")
+
+	fmt.Println("\n下载测速参数:")
+	fmt.Println("  -dn       int         对延迟最低的前N个结果进行下载测速 (默认: 0，不测速)")
+	fmt.Println("  -durl     string      下载测速地址 (默认: https://speed.cloudflare.com/__down?bytes=50000000)")
+	fmt.Println("  -dt       int         单个IP下载测速时长 (默认: 10秒)")
 
-				if ipNet.IP.To4() != nil {
-					ones, _ := ipNet.Mask.Size()
-					ipCount := 1 << uint(32-ones)
+	fmt.Println("\n筛选参数:")
+	fmt.Println("  -colo     string      指定数据中心，多个用逗号分隔 (例: HKG,NRT,LAX,SJC)")
+	fmt.Println("  -tl       int         延迟上限 (默认: 500ms)")
+	fmt.Println("  -tll      int         延迟下限 (默认: 0ms)")
+	fmt.Println("  -tlm      string      延迟筛选使用的指标 (默认: avg)")
+	fmt.Println("                      - 可选: avg, min, median, p90, max, jitter")
+	fmt.Println("  -sort     string      结果排序使用的延迟指标，丢包率相同时生效 (默认: avg)")
+	fmt.Println("  -tlr      float       丢包率上限 (默认: 0.5)")
+	fmt.Println("  -p        string      输出结果数量 (默认: all)")
 
-					cidrList = append(cidrList, cidrInfo{
-						ipNet:   ipNet,
-						ipCount: ipCount,
-					})
+	fmt.Println("\n输出选项:")
+	fmt.Println("  -nocsv                不生成CSV文件 (默认: 不使用)")
+	fmt.Println("  -stream   string      测试过程中将结果以NDJSON实时输出到标准输出 (默认: 不使用)")
+	fmt.Println("                      - cidr: 每个CIDR完成并符合筛选条件时输出一行")
+	fmt.Println("                      - ip: 每个IP完成并符合筛选条件时输出一行")
+	fmt.Println("                      - 使用后提示信息、进度条和结果摘要都输出到标准错误，可直接用管道传给其他程序")
+	fmt.Println("  -ipout    string      输出每个IP的测试结果 (默认: 不使用)")
+	fmt.Println("                      - 以 .json 结尾时输出JSON，否则输出CSV")
+	printIPListHelp()
+	fmt.Println("                      - 测速后从符合条件的CIDR中生成，不需要测速时请使用 gen 命令")
+}
 
-					totalAvailableIPs += ipCount
+// 打印配置文件参数的帮助信息
+func printConfigHelp() {
+	fmt.Println("  -config   string      YAML配置文件，参数名与命令行参数相同 (默认: 不使用)")
+	fmt.Println("                      - 命令行中明确指定的参数优先于配置文件")
+	fmt.Println("                      - scan、gen 和 colo 可共用同一个配置文件，不属于当前命令的参数会被忽略")
+	fmt.Println("  -profile  string      使用配置文件 profiles 中的指定配置，覆盖顶层的同名参数")
+}
 
-					// 一旦总IP数量足够，就可以开始生成随机IP
-					if totalAvailableIPs >= targetCount {
-						break
-					}
-				}
-			}
+// 打印 gen 命令的帮助信息
+func printGenHelp() {
+	fmt.Println("用法: cfspeed gen [参数]")
+	fmt.Println("\n不进行测速，直接从CIDR生成随机IP列表，必须至少使用 -useip4 或 -useip6")
+	printSourceHelp()
+	fmt.Println("\nIP列表参数:")
+	printIPListHelp()
+	fmt.Println("\n配置文件参数:")
+	printConfigHelp()
+}
 
-			// 如果总IP数量不足，则使用所有可用的IP
-			if totalAvailableIPs < targetCount {
-				fmt.Printf("警告: 可用IPv4地址总数(%d)小于请求数量(%d)\n", totalAvailableIPs, targetCount)
-				targetCount = totalAvailableIPs
-			}
+// 打印 colo 命令的帮助信息
+func printColoHelp() {
+	fmt.Println("用法: cfspeed colo [参数] <IP>...")
+	fmt.Println("\n查询IP所在的数据中心，参数需要写在IP之前")
+	printColoFlagsHelp()
+	fmt.Println("\n配置文件参数:")
+	printConfigHelp()
+}
 
-			// 循环生成IP直到达到指定数量
-			cidrIndex := 0
+// 打印 locations 命令的帮助信息
+func printLocationsHelp() {
+	fmt.Println("用法: cfspeed locations [参数]")
+	fmt.Println("\n参数:")
+	fmt.Println("  -locations string     从本地JSON文件读取数据中心位置信息 (默认: 联网获取，获取失败时使用缓存或内置数据)")
+	fmt.Println("  -region   string      只显示指定区域的数据中心 (例: \"Asia Pacific\")")
+}
 
-			for ipv4Count < targetCount && len(cidrList) > 0 {
-				// 获取当前CIDR
-				currentCIDR := cidrList[cidrIndex]
+// 打印 report 命令的帮助信息
+func printReportHelp() {
+	fmt.Println("用法: cfspeed report [参数] <results.json>")
+	fmt.Println("\n打印 scan -format json 输出的结果文件的摘要，参数需要写在文件之前")
+	fmt.Println("\n参数:")
+	fmt.Println("  -colo     string      只显示指定数据中心的结果，多个用逗号分隔")
+	fmt.Println("  -top      int         最佳结果表格显示的数量 (默认: 10)")
+}
 
-				// 使用通用函数生成随机IPv4地址
-				ipStr := generateRandomIPv4Address(currentCIDR.ipNet)
+// 时间格式化
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
 
-				if ipStr != "" {
-					ipList = append(ipList, ipStr)
-					ipv4Count++
-				}
+	if h > 0 {
+		return fmt.Sprintf("%d时%d分%d秒", h, m, s)
+	}
+	if m > 0 {
+		return fmt.Sprintf("%d分%d秒", m, s)
+	}
+	return fmt.Sprintf("%d秒", s)
+}
 
-				// 移动到下一个CIDR
-				cidrIndex = (cidrIndex + 1) % len(cidrList)
-			}
-		}
+// 写入结果到CSV
+func writeResultsToCSV(results []scan.TestResult, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	// 处理 IPv6
-	if ipv6Mode != "" && hasIPv6CIDR {
-		ipv6Count := 0
-		ipv6Limit := 1000000 // 设置IPv6上限为100万
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
 
-		if count, err := strconv.Atoi(ipv6Mode); err == nil && count > 0 {
-			targetCount := count
-			if targetCount > ipv6Limit {
-				targetCount = ipv6Limit
-				fmt.Printf("IPv6生成数量已限制为 %d 个\n", ipv6Limit)
-			}
+	// 写入标题行
+	header := []string{"CIDR", "数据中心", "区域", "城市", "平均延迟", "平均丢包"}
+	header = append(header, statsHeader...)
+	if isTLSMode() {
+		header = append(header, "TLS握手")
+	}
+	if isTraceMode() {
+		header = append(header, traceHeader...)
+	}
+	if useColoCache() {
+		header = append(header, "数据中心来源")
+	}
+	if *dlCount > 0 {
+		header = append(header, "IP", "下载速度(MiB/s)")
+	}
+	err = writer.Write(header)
+	if err != nil {
+		return err
+	}
 
-			// 准备CIDR列表
-			type cidrInfo struct {
-				ipNet    *net.IPNet
-				maskSize int
-			}
+	// 写入数据行
+	for _, result := range results {
+		// 直接使用原始CIDR，不尝试转换
+		row := []string{
+			result.CIDR,
+			result.DataCenter,
+			result.Region,
+			result.City,
+			formatMillis(result.AvgLatency),
+			fmt.Sprintf("%.1f", result.LossRate*100),
+		}
+		row = append(row, statsColumns(result)...)
+		if isTLSMode() {
+			row = append(row, formatMillis(result.TLSLatency))
+		}
+		if isTraceMode() {
+			row = append(row, traceColumns(result)...)
+		}
+		if useColoCache() {
+			row = append(row, result.ColoSource)
+		}
+		if *dlCount > 0 {
+			row = append(row, result.IP, fmt.Sprintf("%.2f", result.DownloadSpeed))
+		}
 
-			var cidrList []cidrInfo
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
 
-			hasLargeCIDR := false  // 标记是否有/0到/108的大CIDR
-			totalSmallCIDRIPs := 0 // 记录/109到/128的CIDR的IP总数
+	return nil
+}
 
-			// 第一次遍历：检查IP数量是否足够
-			for _, result := range results {
-				_, ipNet, err := net.ParseCIDR(result.CIDR)
-				if err != nil || ipNet.IP.To4() != nil {
-					continue // 跳过无效CIDR和非IPv6
-				}
+// CSV中的延迟分布列，位于平均丢包之后
+var statsHeader = []string{"最低延迟", "中位延迟", "P90延迟", "最高延迟", "抖动"}
 
-				ones, _ := ipNet.Mask.Size()
-				// 检查是否有/0到/108的大CIDR
-				if ones <= 108 {
-					hasLargeCIDR = true
-					break
-				} else {
-					// 计算小CIDR的IP数量并累加
-					ipCount := 1 << uint(128-ones)
-					totalSmallCIDRIPs += ipCount
-				}
+func statsColumns(result scan.TestResult) []string {
+	return []string{
+		formatMillis(result.Latency.Min),
+		formatMillis(result.Latency.Median),
+		formatMillis(result.Latency.P90),
+		formatMillis(result.Latency.Max),
+		formatMillis(result.Latency.Jitter),
+	}
+}
 
-				// 如果小CIDR的IP总数已经足够，也可以停止检查
-				if totalSmallCIDRIPs >= targetCount {
-					break
-				}
-			}
+// CSV中 /cdn-cgi/trace 的信息列，仅 trace 查询方式
+var traceHeader = []string{"出口IP", "出口位置", "HTTP版本", "TLS版本", "WARP"}
 
-			// 第二次遍历：收集所有CIDR
-			for _, result := range results {
-				_, ipNet, err := net.ParseCIDR(result.CIDR)
-				if err != nil || ipNet.IP.To4() != nil {
-					continue
-				}
-				ones, _ := ipNet.Mask.Size()
-				cidrList = append(cidrList, cidrInfo{
-					ipNet:    ipNet,
-					maskSize: ones,
-				})
-			}
+func traceColumns(result scan.TestResult) []string {
+	return []string{
+		result.Trace.IP,
+		result.Trace.Loc,
+		result.Trace.HTTP,
+		result.Trace.TLS,
+		result.Trace.Warp,
+	}
+}
 
-			// 如果没有大CIDR且小CIDR的IP总数不足，调整目标数量
-			if !hasLargeCIDR && totalSmallCIDRIPs < targetCount {
-				fmt.Printf("警告: 可用IPv6地址总数(%d)小于请求数量(%d)\n", totalSmallCIDRIPs, targetCount)
-				targetCount = totalSmallCIDRIPs
-			}
+// 以毫秒格式化延迟，保留两位小数
+func formatMillis(d time.Duration) string {
+	return fmt.Sprintf("%.2f", scan.Millis(d))
+}
 
-			// 循环生成IP直到达到指定数量
-			cidrIndex := 0
+// 解析 -format 参数，-nocsv 时去掉 csv
+func parseFormats(value string) ([]string, error) {
+	var formats []string
+	for _, format := range splitList(strings.ToLower(value)) {
+		switch format {
+		case "csv", "json", "ndjson":
+		default:
+			return nil, fmt.Errorf("不支持的输出格式: %s", format)
+		}
+		if format == "csv" && *noCSV {
+			continue
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
 
-			for ipv6Count < targetCount && len(cidrList) > 0 {
-				// 获取当前CIDR
-				currentCIDR := cidrList[cidrIndex]
+// 返回指定格式的结果文件名。CSV 和明确指定 -o 时的唯一格式使用 -o 的文件名，
+// 其余格式按格式替换扩展名，例如 IP_Speed.csv 对应 IP_Speed.json
+func outputFileName(filename, format string, formats []string, explicit bool) string {
+	if format == "csv" || (explicit && len(formats) == 1) {
+		return filename
+	}
+	ext := filepath.Ext(filename)
+	name := strings.TrimSuffix(filename, ext) + "." + format
+	// 与 CSV 文件同名时追加扩展名，避免覆盖，例如 -o result.json 时为 result.json.json
+	if name == filename && slices.Contains(formats, "csv") {
+		name = filename + "." + format
+	}
+	return name
+}
 
-				// 使用通用函数生成随机IPv6地址
-				ipStr := generateRandomIPv6Address(currentCIDR.ipNet)
+// 本次运行的CIDR来源
+func resultSources() []string {
+	var sources []string
+	if *cidrFlag != "" {
+		sources = append(sources, splitList(*cidrFlag)...)
+	} else if *urlFlag != "" {
+		sources = append(sources, *urlFlag)
+	} else if *fileFlag != "" {
+		sources = append(sources, *fileFlag)
+	}
+	return sources
+}
 
-				if ipStr != "" {
-					ipList = append(ipList, ipStr)
-					ipv6Count++
-				}
+// 写入 JSON 格式的结果文档，包含运行元数据和 fs 中的全部参数
+func writeResultsToJSON(results []scan.TestResult, filename string, fs *flag.FlagSet) error {
+	flags := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	if results == nil {
+		results = []scan.TestResult{}
+	}
 
-				// 移动到下一个CIDR
-				cidrIndex = (cidrIndex + 1) % len(cidrList)
-			}
+	report := scan.Report{
+		Tool:      "cfspeed",
+		Version:   version,
+		StartTime: startTime,
+		EndTime:   time.Now(),
+		Sources:   resultSources(),
+		Flags:     flags,
+		Seed:      *seedFlag,
+		Results:   results,
+	}
 
-			if ipv6Count > 0 {
-				fmt.Printf("成功生成 %d 个IPv6地址\n", ipv6Count)
-			}
-		}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
 
-	// 写入文件
+// 写入 NDJSON 格式的结果，每行一个结果
+func writeResultsToNDJSON(results []scan.TestResult, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -1550,43 +1551,83 @@ func generateIPFile(results []TestResult, ipv4Mode, ipv6Mode, filename string) e
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	for _, ip := range ipList {
-		_, err := writer.WriteString(ip + "\n")
-		if err != nil {
+	encoder := json.NewEncoder(writer)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
 			return err
 		}
 	}
 	return writer.Flush()
 }
 
-// 写入结果到CSV
-func writeResultsToCSV(results []TestResult, filename string) error {
+// 实时输出的编码器，结果处理协程依次调用，无需加锁
+var streamEncoder = json.NewEncoder(streamOut)
+
+// 将一个结果以NDJSON格式写入标准输出
+func streamResult(result scan.TestResult) {
+	if err := streamEncoder.Encode(result); err != nil {
+		fmt.Printf("实时输出结果失败: %v\n", err)
+	}
+}
+
+// 写入每个IP的结果，根据文件扩展名选择JSON或CSV格式
+func writeIPResults(results []scan.TestResult, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if results == nil {
+			results = []scan.TestResult{}
+		}
+		return encoder.Encode(results)
+	}
+
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
 	// 写入标题行
-	err = writer.Write([]string{"CIDR", "数据中心", "区域", "城市", "平均延迟", "平均丢包"})
+	header := []string{"IP", "CIDR", "数据中心", "区域", "城市", "平均延迟", "平均丢包"}
+	header = append(header, statsHeader...)
+	if isTLSMode() {
+		header = append(header, "TLS握手")
+	}
+	if isTraceMode() {
+		header = append(header, traceHeader...)
+	}
+	if useColoCache() {
+		header = append(header, "数据中心来源")
+	}
+	err = writer.Write(header)
 	if err != nil {
 		return err
 	}
 
 	// 写入数据行
 	for _, result := range results {
-		// 直接使用原始CIDR，不尝试转换
 		row := []string{
+			result.IP,
 			result.CIDR,
 			result.DataCenter,
 			result.Region,
 			result.City,
-			fmt.Sprintf("%d", result.AvgLatency), // 直接使用 int 值
+			formatMillis(result.AvgLatency),
 			fmt.Sprintf("%.1f", result.LossRate*100),
 		}
+		row = append(row, statsColumns(result)...)
+		if isTLSMode() {
+			row = append(row, formatMillis(result.TLSLatency))
+		}
+		if isTraceMode() {
+			row = append(row, traceColumns(result)...)
+		}
+		if useColoCache() {
+			row = append(row, result.ColoSource)
+		}
 
 		err = writer.Write(row)
 		if err != nil {
@@ -1597,8 +1638,15 @@ func writeResultsToCSV(results []TestResult, filename string) error {
 	return nil
 }
 
+// 结果摘要的显示选项
+type summaryOptions struct {
+	tls      bool // 显示TLS握手列
+	download bool // 显示下载速度列
+	top      int  // 最佳结果表格显示的数量
+}
+
 // 打印结果摘要
-func printResultsSummary(results []TestResult) {
+func printResultsSummary(results []scan.TestResult, opts summaryOptions) {
 	if len(results) == 0 {
 		fmt.Println("\n未找到符合条件的结果")
 		return
@@ -1606,12 +1654,20 @@ func printResultsSummary(results []TestResult) {
 
 	fmt.Println("\n测试结果摘要:")
 
+	// trace 查询方式下显示本机的出口IP
+	for _, result := range results {
+		if result.Trace.IP != "" {
+			fmt.Printf("\n出口IP: %s (%s)\n", result.Trace.IP, result.Trace.Loc)
+			break
+		}
+	}
+
 	// 统计数据中心分布和延迟
 	dcMap := make(map[string]struct {
 		count        int
-		minLatency   int
-		maxLatency   int
-		totalLatency int
+		minLatency   time.Duration
+		maxLatency   time.Duration
+		totalLatency time.Duration
 	})
 
 	// 统计未知数据中心的数量
@@ -1621,28 +1677,29 @@ func printResultsSummary(results []TestResult) {
 		if dc == "Unknown" {
 			unknownCount++
 		}
+		latency := result.TotalLatency()
 
 		stats, exists := dcMap[dc]
 		if !exists {
 			stats = struct {
 				count        int
-				minLatency   int
-				maxLatency   int
-				totalLatency int
+				minLatency   time.Duration
+				maxLatency   time.Duration
+				totalLatency time.Duration
 			}{
-				minLatency: result.AvgLatency,
-				maxLatency: result.AvgLatency,
+				minLatency: latency,
+				maxLatency: latency,
 			}
 		}
 
 		stats.count++
-		stats.totalLatency += result.AvgLatency
+		stats.totalLatency += latency
 
-		if result.AvgLatency < stats.minLatency {
-			stats.minLatency = result.AvgLatency
+		if latency < stats.minLatency {
+			stats.minLatency = latency
 		}
-		if result.AvgLatency > stats.maxLatency {
-			stats.maxLatency = result.AvgLatency
+		if latency > stats.maxLatency {
+			stats.maxLatency = latency
 		}
 
 		dcMap[dc] = stats
@@ -1657,40 +1714,37 @@ func printResultsSummary(results []TestResult) {
 	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
 
 	for dc, stats := range dcMap {
-		avgLatency := stats.totalLatency / stats.count
+		avgLatency := stats.totalLatency / time.Duration(stats.count)
 		table.Append([]string{
 			dc,
 			fmt.Sprintf("%d", stats.count),
-			fmt.Sprintf("%dms", stats.maxLatency),
-			fmt.Sprintf("%dms", avgLatency),
-			fmt.Sprintf("%dms", stats.minLatency),
+			formatMillis(stats.maxLatency) + "ms",
+			formatMillis(avgLatency) + "ms",
+			formatMillis(stats.minLatency) + "ms",
 		})
 	}
 	table.Render()
 
-	// 计算总体延迟统计
-	var totalLatency int
-	minLatency := results[0].AvgLatency
-	maxLatency := results[0].AvgLatency
-	for _, result := range results {
-		totalLatency += result.AvgLatency
-		if result.AvgLatency < minLatency {
-			minLatency = result.AvgLatency
-		}
-		if result.AvgLatency > maxLatency {
-			maxLatency = result.AvgLatency
-		}
-	}
-
 	fmt.Println()
 
 	// 显示最佳结果表格
 	resultTable := tablewriter.NewWriter(os.Stdout)
-	resultTable.SetHeader([]string{"CIDR", "城市(数据中心)", "平均延迟", "平均丢包"})
+	header := []string{"CIDR", "城市(数据中心)", "平均延迟", "中位延迟", "P90延迟", "抖动", "平均丢包"}
+	alignment := []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT,
+		tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT}
+	if opts.tls {
+		header = append(header, "TLS握手")
+		alignment = append(alignment, tablewriter.ALIGN_RIGHT)
+	}
+	if opts.download {
+		header = append(header, "下载速度")
+		alignment = append(alignment, tablewriter.ALIGN_RIGHT)
+	}
+	resultTable.SetHeader(header)
 	resultTable.SetBorder(false)
-	resultTable.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+	resultTable.SetColumnAlignment(alignment)
 
-	limit := 10
+	limit := opts.top
 	if len(results) < limit {
 		limit = len(results)
 	}
@@ -1698,44 +1752,24 @@ func printResultsSummary(results []TestResult) {
 	for i := 0; i < limit; i++ {
 		result := results[i]
 		locationInfo := fmt.Sprintf("%s(%s)", result.City, result.DataCenter)
-		resultTable.Append([]string{
+		row := []string{
 			result.CIDR,
 			locationInfo,
-			fmt.Sprintf("%dms", result.AvgLatency),
+			formatMillis(result.AvgLatency) + "ms",
+			formatMillis(result.Latency.Median) + "ms",
+			formatMillis(result.Latency.P90) + "ms",
+			formatMillis(result.Latency.Jitter) + "ms",
 			fmt.Sprintf("%.1f%%", result.LossRate*100),
-		})
+		}
+		if opts.tls {
+			row = append(row, formatMillis(result.TLSLatency)+"ms")
+		}
+		if opts.download {
+			row = append(row, fmt.Sprintf("%.2fMiB/s", result.DownloadSpeed))
+		}
+		resultTable.Append(row)
 	}
 	resultTable.Render()
 
 	fmt.Println()
 }
-
-// 在计算完平均值后调用
-func (g *CIDRGroup) finalize() {
-	if len(g.Data.Results) > 0 {
-		// 计算平均值
-		var totalLatency int
-		var totalLossRate float64
-		for _, r := range g.Data.Results {
-			totalLatency += r.AvgLatency
-			totalLossRate += r.LossRate
-		}
-
-		// 从对象池获取结果对象
-		g.Result = resultPool.Get().(*TestResult)
-
-		// 填充结果
-		g.Result.CIDR = g.CIDR
-		g.Result.DataCenter = g.Data.Results[0].DataCenter
-		g.Result.Region = g.Data.Results[0].Region
-		g.Result.City = g.Data.Results[0].City
-		g.Result.AvgLatency = totalLatency / len(g.Data.Results)
-		g.Result.LossRate = totalLossRate / float64(len(g.Data.Results))
-
-		// 清理临时数据并放回对象池
-		g.Data.Results = g.Data.Results[:0]
-		g.Data.IPs = g.Data.IPs[:0]
-		testDataPool.Put(g.Data)
-		g.Data = nil
-	}
-}
@@ -0,0 +1,20 @@
+module github.com/GuangYu-yu/cfspeed
+
+go 1.24
+
+require (
+	github.com/cheggaaa/pb/v3 v3.1.5
+	github.com/olekukonko/tablewriter v0.0.5
+	golang.org/x/sync v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/VividCortex/ewma v1.2.0 // indirect
+	github.com/fatih/color v1.15.0 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.19 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/rivo/uniseg v0.2.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
+)
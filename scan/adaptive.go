@@ -0,0 +1,56 @@
+package scan
+
+import "context"
+
+// 一轮测试的参数
+type scanPass struct {
+	ipPerCIDR int
+	testCount int
+	coarse    bool                   // 粗筛阶段，不查询数据中心也不记录单个IP结果
+	include   func(*TestResult) bool // CIDR结果的筛选条件
+}
+
+// 按配置进行的完整测试
+func (s *Scanner) fullPass() scanPass {
+	return scanPass{
+		ipPerCIDR: s.opts.IPPerCIDR,
+		testCount: s.opts.TestCount,
+		include:   s.shouldInclude,
+	}
+}
+
+// 粗筛：每个CIDR只选1个IP探测1次
+func (s *Scanner) coarsePass() scanPass {
+	return scanPass{
+		ipPerCIDR: 1,
+		testCount: 1,
+		coarse:    true,
+		include:   s.passesCoarse,
+	}
+}
+
+// 粗筛只检查延迟上限和丢包，单次探测的延迟波动较大，不检查延迟下限
+func (s *Scanner) passesCoarse(result *TestResult) bool {
+	return result.LossRate <= s.opts.MaxLossRate && result.Metric(s.opts.FilterMetric) <= s.opts.MaxLatency
+}
+
+// 自适应测速的第一阶段，返回通过粗筛的CIDR
+func (s *Scanner) coarseScan(ctx context.Context, cidrs []string) []string {
+	s.opts.Logf.printf("自适应测速: 粗筛 %d 个CIDR\n", len(cidrs))
+
+	cidrGroups := make([]cidrGroup, len(cidrs))
+	for i, cidr := range cidrs {
+		cidrGroups[i] = cidrGroup{
+			CIDR: cidr,
+		}
+	}
+
+	var survivors []string
+	for _, group := range s.testIPs(ctx, cidrGroups, s.coarsePass()) {
+		survivors = append(survivors, group.CIDR)
+		resultPool.Put(group.Result)
+	}
+
+	s.opts.Logf.printf("自适应测速: %d 个CIDR通过粗筛，开始完整测试\n", len(survivors))
+	return survivors
+}
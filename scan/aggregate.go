@@ -0,0 +1,104 @@
+package scan
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// NormalizeStats NormalizeCIDRs 的处理统计
+type NormalizeStats struct {
+	Invalid    int // 无法解析而被忽略的项
+	Duplicates int // 重复的CIDR
+	Overlaps   int // 被其他CIDR包含的CIDR
+	Merged     int // 与相邻CIDR合并而减少的数量
+}
+
+// Removed 返回去除和合并的CIDR总数
+func (st NormalizeStats) Removed() int {
+	return st.Invalid + st.Duplicates + st.Overlaps + st.Merged
+}
+
+// NormalizeCIDRs 规范化CIDR列表：去掉主机位，删除重复和被包含的CIDR，
+// 并将相邻的CIDR合并为更大的前缀。单个IP视为 /32 或 /128。
+// 返回的列表按地址排序，IPv4 在前
+func NormalizeCIDRs(cidrList []string) ([]string, NormalizeStats) {
+	return normalizeCIDRs(cidrList, true)
+}
+
+// DedupeCIDRs 与 NormalizeCIDRs 相同，但不合并相邻的CIDR，
+// 用于需要保留每个输入CIDR的场景，例如按CIDR随机选取子网
+func DedupeCIDRs(cidrList []string) ([]string, NormalizeStats) {
+	return normalizeCIDRs(cidrList, false)
+}
+
+func normalizeCIDRs(cidrList []string, merge bool) ([]string, NormalizeStats) {
+	var stats NormalizeStats
+
+	prefixes := make([]netip.Prefix, 0, len(cidrList))
+	for _, cidr := range cidrList {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			stats.Invalid++
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	// 按地址排序，地址相同时较大的前缀在前，包含关系的前缀因此相邻
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	// 删除重复和被包含的前缀
+	unique := prefixes[:0]
+	for _, p := range prefixes {
+		if n := len(unique); n > 0 {
+			last := unique[n-1]
+			if last == p {
+				stats.Duplicates++
+				continue
+			}
+			if last.Addr().Is4() == p.Addr().Is4() && last.Bits() < p.Bits() && last.Contains(p.Addr()) {
+				stats.Overlaps++
+				continue
+			}
+		}
+		unique = append(unique, p)
+	}
+
+	// 合并相邻的前缀，合并后的前缀可能继续与前一个合并
+	var merged []netip.Prefix
+	for _, p := range unique {
+		merged = append(merged, p)
+		for n := len(merged); merge && n >= 2; n = len(merged) {
+			parent, ok := mergePrefixes(merged[n-2], merged[n-1])
+			if !ok {
+				break
+			}
+			merged = append(merged[:n-2], parent)
+			stats.Merged++
+		}
+	}
+
+	result := make([]string, len(merged))
+	for i, p := range merged {
+		result[i] = p.String()
+	}
+	return result, stats
+}
+
+// 如果 a 和 b 是同一个父前缀的两半，返回父前缀
+func mergePrefixes(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+		return netip.Prefix{}, false
+	}
+	parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+	low, high := splitPrefix(parent)
+	if low != a || high != b {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
@@ -0,0 +1,76 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeCIDRs(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidrs     []string
+		want      []string
+		wantStats NormalizeStats
+	}{
+		{
+			name:  "duplicates",
+			cidrs: []string{"10.0.0.0/24", "10.0.0.0/24", " 10.0.0.0/24 "},
+			want:  []string{"10.0.0.0/24"},
+			wantStats: NormalizeStats{
+				Duplicates: 2,
+			},
+		},
+		{
+			name:      "host bits masked",
+			cidrs:     []string{"10.0.0.1/24", "10.0.0.0/24"},
+			want:      []string{"10.0.0.0/24"},
+			wantStats: NormalizeStats{Duplicates: 1},
+		},
+		{
+			name:      "contained",
+			cidrs:     []string{"10.0.1.0/24", "10.0.0.0/16", "10.0.0.5"},
+			want:      []string{"10.0.0.0/16"},
+			wantStats: NormalizeStats{Overlaps: 2},
+		},
+		{
+			name:      "adjacent merged",
+			cidrs:     []string{"10.0.0.0/24", "10.0.3.0/24", "10.0.1.0/24", "10.0.2.0/24"},
+			want:      []string{"10.0.0.0/22"},
+			wantStats: NormalizeStats{Merged: 3},
+		},
+		{
+			name:  "not siblings",
+			cidrs: []string{"10.0.1.0/24", "10.0.2.0/24"},
+			want:  []string{"10.0.1.0/24", "10.0.2.0/24"},
+		},
+		{
+			name:      "mixed families",
+			cidrs:     []string{"2606:4700::/32", "bad", "1.1.1.1", "::ffff:1.1.1.0"},
+			want:      []string{"1.1.1.0/31", "2606:4700::/32"},
+			wantStats: NormalizeStats{Invalid: 1, Merged: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stats := NormalizeCIDRs(tt.cidrs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if stats != tt.wantStats {
+				t.Errorf("stats = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestDedupeCIDRs(t *testing.T) {
+	got, stats := DedupeCIDRs([]string{"104.24.0.0/13", "104.16.0.0/13", "104.16.0.0/13", "104.17.0.0/16"})
+	want := []string{"104.16.0.0/13", "104.24.0.0/13"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if want := (NormalizeStats{Duplicates: 1, Overlaps: 1}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
@@ -0,0 +1,206 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint 记录已完成测试的CIDR及其结果，用于中断后继续测试
+type Checkpoint struct {
+	path   string
+	params map[string]string // 影响测试结果的参数，继续测试时必须一致
+	mutex  sync.Mutex
+	order  []string               // CIDR 完成的顺序
+	done   map[string]*TestResult // 值为 nil 表示测试完成但没有可用结果
+	dirty  bool
+}
+
+// 断点文件格式
+type checkpointFile struct {
+	Params map[string]string `json:"params,omitempty"`
+	Groups []checkpointGroup `json:"groups"`
+}
+
+type checkpointGroup struct {
+	CIDR   string      `json:"cidr"`
+	Result *TestResult `json:"result,omitempty"`
+}
+
+// NewCheckpoint 创建一个空的断点，保存到 path。
+// params 为影响测试结果的参数，与结果一起保存，继续测试时用 CheckParams 检查
+func NewCheckpoint(path string, params map[string]string) *Checkpoint {
+	return &Checkpoint{
+		path:   path,
+		params: params,
+		done:   make(map[string]*TestResult),
+	}
+}
+
+// LoadCheckpoint 从 path 读取断点，之后的进度也保存到 path
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	c := NewCheckpoint(path, file.Params)
+	for _, group := range file.Groups {
+		c.record(group.CIDR, group.Result)
+	}
+	c.dirty = false
+	return c, nil
+}
+
+// CheckParams 检查断点中保存的参数与 params 是否一致，不一致时返回列出差异的错误
+func (c *Checkpoint) CheckParams(params map[string]string) error {
+	names := make(map[string]bool)
+	for name := range c.params {
+		names[name] = true
+	}
+	for name := range params {
+		names[name] = true
+	}
+
+	var diffs []string
+	for name := range names {
+		if saved, current := c.params[name], params[name]; saved != current {
+			diffs = append(diffs, fmt.Sprintf("%s 为 %q，当前为 %q", name, saved, current))
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	sort.Strings(diffs)
+	return fmt.Errorf("参数与断点不一致: %s", strings.Join(diffs, "; "))
+}
+
+// Len 返回已完成的CIDR数量
+func (c *Checkpoint) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.order)
+}
+
+// 记录一个已完成的CIDR，result 为合并后、筛选前的结果
+func (c *Checkpoint) record(cidr string, result *TestResult) {
+	var saved *TestResult
+	if result != nil {
+		copied := *result
+		copied.Samples = nil // 原始样本不写入断点
+		saved = &copied
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.done[cidr]; !ok {
+		c.order = append(c.order, cidr)
+	}
+	c.done[cidr] = saved
+	c.dirty = true
+}
+
+// 查询CIDR是否已完成，已完成时返回记录的结果
+func (c *Checkpoint) lookup(cidr string) (*TestResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result, ok := c.done[cidr]
+	return result, ok
+}
+
+// Save 将断点写入文件，先写临时文件再重命名，避免中断时留下不完整的文件
+func (c *Checkpoint) Save() error {
+	c.mutex.Lock()
+	if !c.dirty {
+		c.mutex.Unlock()
+		return nil
+	}
+	file := checkpointFile{Params: c.params, Groups: make([]checkpointGroup, 0, len(c.order))}
+	for _, cidr := range c.order {
+		file.Groups = append(file.Groups, checkpointGroup{CIDR: cidr, Result: c.done[cidr]})
+	}
+	c.dirty = false
+	c.mutex.Unlock()
+
+	err := writeFileAtomic(c.path, file)
+	if err != nil {
+		// 保存失败时保留未保存标记，下次继续尝试
+		c.mutex.Lock()
+		c.dirty = true
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// 将 v 编码为JSON写入临时文件后重命名为 path
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Remove 删除断点文件，测试全部完成后调用
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 每隔 interval 保存一次断点，返回的函数停止保存并再保存一次
+func (c *Checkpoint) autoSave(interval time.Duration, logf LogFunc) func() {
+	save := func() {
+		if err := c.Save(); err != nil {
+			logf.printf("保存断点失败: %v\n", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				save()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		save()
+	}
+}
@@ -0,0 +1,42 @@
+package scan
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	params := map[string]string{"ts": "2", "t": "4", "mode": "tcp"}
+
+	c := NewCheckpoint(path, params)
+	c.record("10.0.0.0/24", &TestResult{CIDR: "10.0.0.0/24", IP: "10.0.0.1"})
+	c.record("10.0.1.0/24", nil)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", loaded.Len())
+	}
+	if result, ok := loaded.lookup("10.0.0.0/24"); !ok || result == nil || result.IP != "10.0.0.1" {
+		t.Errorf("lookup(10.0.0.0/24) = %v, %v", result, ok)
+	}
+
+	if err := loaded.CheckParams(map[string]string{"ts": "2", "t": "4", "mode": "tcp"}); err != nil {
+		t.Errorf("same params: %v", err)
+	}
+	for _, changed := range []map[string]string{
+		{"ts": "3", "t": "4", "mode": "tcp"},
+		{"ts": "2", "t": "4"},
+		{"ts": "2", "t": "4", "mode": "tcp", "seed": "1"},
+	} {
+		if err := loaded.CheckParams(changed); err == nil {
+			t.Errorf("CheckParams(%v): want error", changed)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cidrStringPool sync.Map // CIDR 字符串池
+
+// 获取共享 CIDR 字符串的函数
+func getSharedCIDR(cidr string) string {
+	if pooledCIDR, ok := cidrStringPool.Load(cidr); ok {
+		return pooledCIDR.(string)
+	}
+	cidrStringPool.Store(cidr, cidr)
+	return cidr
+}
+
+// FetchOptions 从URL获取CIDR列表时的超时和重试设置
+type FetchOptions struct {
+	Timeout    time.Duration // 单次请求超时
+	Retries    int           // 最大尝试次数
+	RetryDelay time.Duration // 重试间隔
+	Logf       LogFunc       // 输出重试信息
+}
+
+// DefaultFetchOptions 返回与命令行默认值一致的设置
+func DefaultFetchOptions() FetchOptions {
+	return FetchOptions{
+		Timeout:    3 * time.Second,
+		Retries:    10,
+		RetryDelay: 3 * time.Second,
+	}
+}
+
+// FetchCIDRList 从URL获取CIDR列表
+func FetchCIDRList(ctx context.Context, url string, opts FetchOptions) ([]string, error) {
+	maxRetries := opts.Retries
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+	retryDelay := opts.RetryDelay
+	logf := opts.Logf
+
+	var cidrList []string
+	var lastErr error
+
+	// 创建带超时的HTTP客户端
+	client := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+
+	// 重试逻辑
+	for retry := 0; retry < maxRetries; retry++ {
+		if retry > 0 {
+			logf.printf("第 %d 次重试获取CIDR列表...\n", retry)
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			// 只显示重试提示，不显示具体错误
+			logf.printf("获取失败，准备重试...\n")
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
+			// 只显示重试提示，不显示具体错误
+			logf.printf("获取失败，准备重试...\n")
+			continue
+		}
+
+		// 成功获取，解析CIDR列表
+		cidrList, err = ParseCIDRList(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			lastErr = err
+			// 只显示重试提示，不显示具体错误
+			logf.printf("解析失败，准备重试...\n")
+			continue
+		}
+
+		// 检查是否成功获取到CIDR
+		if len(cidrList) > 0 {
+			return cidrList, nil
+		}
+
+		lastErr = fmt.Errorf("获取到的CIDR列表为空")
+		// 只显示重试提示，不显示具体错误
+		logf.printf("获取结果为空，准备重试...\n")
+	}
+
+	// 修改这里，使用lastErr变量而不是创建新的错误
+	return nil, lastErr
+}
+
+// ReadCIDRFile 从文件获取CIDR列表
+func ReadCIDRFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseCIDRList(file)
+}
+
+// ParseCIDRList 解析CIDR列表，忽略空行和 # 开头的注释
+func ParseCIDRList(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	var cidrList []string
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// 处理不同格式的CIDR
+		if !strings.Contains(line, "/") {
+			// 如果是IPv4
+			if strings.Count(line, ".") == 3 {
+				line = line + "/32"
+			}
+			// 如果是IPv6
+			if strings.Contains(line, ":") {
+				line = line + "/128"
+			}
+		}
+
+		cidrList = append(cidrList, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cidrList, nil
+}
+
+// 默认的拆分粒度和拆分后的CIDR数量上限
+const (
+	DefaultSplitIPv4 = 24
+	DefaultSplitIPv6 = 48
+	DefaultMaxGroups = 1 << 20
+)
+
+// SplitOptions CIDR的拆分方式
+type SplitOptions struct {
+	IPv4Bits  int // 大于该前缀的IPv4 CIDR拆分为该前缀长度的子网，0 表示不拆分
+	IPv6Bits  int // 大于该前缀的IPv6 CIDR拆分为该前缀长度的子网，0 表示不拆分
+	MaxGroups int // 拆分后的CIDR数量上限，超过时返回错误，0 表示不限制
+
+	// 每个CIDR拆分后随机选取的子网数量，0 表示使用全部子网。
+	// 随机选取时不会生成完整的子网列表；相邻的CIDR如果已被 NormalizeCIDRs 合并，
+	// 只按合并后的CIDR选取，需要按输入的CIDR选取时请使用 DedupeCIDRs
+	SampleGroups int
+
+	// 随机选取子网使用的随机数生成器，为 nil 时使用当前时间作为种子
+	Rand *rand.Rand
+}
+
+// DefaultSplitOptions 返回与命令行默认值一致的拆分方式
+func DefaultSplitOptions() SplitOptions {
+	return SplitOptions{
+		IPv4Bits:  DefaultSplitIPv4,
+		IPv6Bits:  DefaultSplitIPv6,
+		MaxGroups: DefaultMaxGroups,
+	}
+}
+
+// ExpandCIDRs 使用默认的拆分方式扩展CIDR列表，将大于/24的IPv4 CIDR拆分为多个/24，
+// 将大于/48的IPv6 CIDR拆分为多个/48，拆分后超过 DefaultMaxGroups 个时返回 nil。
+//
+// Deprecated: 使用 SplitCIDRs，可以指定拆分粒度并获取错误信息
+func ExpandCIDRs(cidrList []string) []string {
+	expandedList, _ := SplitCIDRs(cidrList, DefaultSplitOptions())
+	return expandedList
+}
+
+// SplitCIDRs 按 opts 将CIDR列表拆分为测试组，无法解析的项会被忽略。
+// 输入中重复或重叠的CIDR不会被处理，需要时请先调用 NormalizeCIDRs
+func SplitCIDRs(cidrList []string, opts SplitOptions) ([]string, error) {
+	type splitItem struct {
+		cidr   string
+		prefix netip.Prefix
+		bits   int // 拆分后的前缀长度
+	}
+
+	// 先计算拆分后的数量，超过上限或无法表示时不生成列表
+	items := make([]splitItem, 0, len(cidrList))
+	var total uint64
+	for _, cidr := range cidrList {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+
+		bits := opts.IPv6Bits
+		if prefix.Addr().Is4() {
+			bits = opts.IPv4Bits
+		}
+		if bits <= prefix.Bits() {
+			bits = prefix.Bits()
+		}
+
+		count, ok := splitCount(prefix.Bits(), bits)
+		if opts.SampleGroups > 0 && (!ok || count > opts.SampleGroups) {
+			count, ok = opts.SampleGroups, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s 拆分为 /%d 后的数量过大", cidr, bits)
+		}
+		total += uint64(count)
+		if total > math.MaxInt {
+			return nil, fmt.Errorf("拆分后的CIDR数量过大")
+		}
+		items = append(items, splitItem{cidr: cidr, prefix: prefix, bits: bits})
+	}
+	if opts.MaxGroups > 0 && total > uint64(opts.MaxGroups) {
+		return nil, fmt.Errorf("拆分后共有 %d 个CIDR，超过上限 %d", total, opts.MaxGroups)
+	}
+
+	// 只有设置了上限时才按数量预分配，避免不限制数量时分配过大的内存
+	capacity := len(items)
+	if opts.MaxGroups > 0 {
+		capacity = int(total)
+	}
+	rng := ensureRand(opts.Rand)
+	expandedList := make([]string, 0, capacity)
+	for _, item := range items {
+		if item.bits == item.prefix.Bits() {
+			// 不需要拆分，直接添加，使用共享 CIDR 字符串
+			expandedList = append(expandedList, getSharedCIDR(item.cidr))
+			continue
+		}
+
+		count, ok := splitCount(item.prefix.Bits(), item.bits)
+		if opts.SampleGroups > 0 && (!ok || count > opts.SampleGroups) {
+			for _, sub := range sampleSubPrefixes(rng, item.prefix, item.bits, opts.SampleGroups) {
+				expandedList = append(expandedList, sub.String())
+			}
+			continue
+		}
+
+		sub := netip.PrefixFrom(item.prefix.Addr(), item.bits)
+		for i := 0; i < count; i++ {
+			expandedList = append(expandedList, sub.String())
+			sub = nextPrefix(sub)
+		}
+	}
+	return expandedList, nil
+}
+
+// 前缀长度为 ones 的CIDR拆分为前缀长度 bits 的子网数量，超出 int 范围时返回 false
+func splitCount(ones, bits int) (int, bool) {
+	return pow2(bits - ones)
+}
+
+// 返回 2 的 n 次方，超出 int 范围时返回 false
+func pow2(n int) (int, bool) {
+	if n > strconv.IntSize-2 {
+		return 0, false
+	}
+	return int(uint64(1) << uint(n)), true
+}
+
+// 返回与 p 相邻的下一个相同长度的前缀，超出地址范围时回到起始地址
+func nextPrefix(p netip.Prefix) netip.Prefix {
+	addr := p.Addr().AsSlice()
+	bit := p.Bits() - 1
+
+	// 在前缀的最后一位加一，并向高位进位
+	inc := byte(0x80) >> (bit % 8)
+	for i := bit / 8; i >= 0; i-- {
+		old := addr[i]
+		addr[i] += inc
+		if addr[i] > old {
+			break
+		}
+		inc = 1
+	}
+
+	next, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(next, p.Bits())
+}
+
+// 从 p 拆分为前缀长度 bits 的子网中均匀随机选取 n 个不重复的子网，按地址排序。
+// 调用方需保证子网数量大于 n
+func sampleSubPrefixes(rng *rand.Rand, p netip.Prefix, bits, n int) []netip.Prefix {
+	result := make([]netip.Prefix, 0, n)
+
+	count, ok := splitCount(p.Bits(), bits)
+	if ok {
+		for _, index := range sampleIndexes(rng, count, n) {
+			result = append(result, subPrefixAt(p, bits, uint64(index)))
+		}
+	} else {
+		// 子网数量过大，直接随机生成子网位，重复的概率可以忽略，重复时重新生成
+		chosen := make(map[netip.Prefix]bool, n)
+		for len(result) < n {
+			sub := randomSubPrefix(rng, p, bits)
+			if chosen[sub] {
+				continue
+			}
+			chosen[sub] = true
+			result = append(result, sub)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Addr().Less(result[j].Addr())
+	})
+	return result
+}
+
+// 返回 p 拆分为前缀长度 bits 的子网中的第 index 个
+func subPrefixAt(p netip.Prefix, bits int, index uint64) netip.Prefix {
+	addr := p.Masked().Addr().AsSlice()
+	for k := 0; index>>uint(k) != 0; k++ {
+		if index>>uint(k)&1 == 1 {
+			bit := bits - 1 - k
+			addr[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	sub, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(sub, bits)
+}
+
+// 随机设置 p 的前缀之后、bits 之前的位，返回前缀长度为 bits 的子网
+func randomSubPrefix(rng *rand.Rand, p netip.Prefix, bits int) netip.Prefix {
+	addr := p.Masked().Addr().AsSlice()
+	for bit := p.Bits(); bit < bits; bit++ {
+		if rng.Intn(2) == 1 {
+			addr[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	sub, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(sub, bits)
+}
@@ -0,0 +1,137 @@
+package scan
+
+import (
+	"math/rand"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSplitCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		opts    SplitOptions
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "default",
+			cidrs: []string{"10.0.0.0/22", "2606:4700::/47", "1.1.1.1/32"},
+			opts:  DefaultSplitOptions(),
+			want: []string{
+				"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24",
+				"2606:4700::/48", "2606:4700:1::/48",
+				"1.1.1.1/32",
+			},
+		},
+		{
+			name:  "none",
+			cidrs: []string{"10.0.0.0/16", "2606:4700::/32"},
+			opts:  SplitOptions{},
+			want:  []string{"10.0.0.0/16", "2606:4700::/32"},
+		},
+		{
+			name:  "carry",
+			cidrs: []string{"10.0.254.0/23"},
+			opts:  SplitOptions{IPv4Bits: 24},
+			want:  []string{"10.0.254.0/24", "10.0.255.0/24"},
+		},
+		{
+			name:  "invalid ignored",
+			cidrs: []string{"bad", "10.0.0.0/24"},
+			opts:  DefaultSplitOptions(),
+			want:  []string{"10.0.0.0/24"},
+		},
+		{
+			name:    "over budget",
+			cidrs:   []string{"10.0.0.0/16"},
+			opts:    SplitOptions{IPv4Bits: 24, MaxGroups: 10},
+			wantErr: true,
+		},
+		{
+			name:    "too many groups with budget",
+			cidrs:   []string{"2606:4700::/32"},
+			opts:    SplitOptions{IPv6Bits: 64, MaxGroups: 10},
+			wantErr: true,
+		},
+		{
+			name:    "unrepresentable without budget",
+			cidrs:   []string{"2606:4700::/32"},
+			opts:    SplitOptions{IPv6Bits: 96},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitCIDRs(tt.cidrs, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCIDRsSample(t *testing.T) {
+	opts := SplitOptions{
+		IPv4Bits:     24,
+		IPv6Bits:     96,
+		SampleGroups: 5,
+		Rand:         rand.New(rand.NewSource(1)),
+	}
+	got, err := SplitCIDRs([]string{"10.0.0.0/16", "2606:4700::/32", "192.168.0.0/22"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 14 {
+		t.Fatalf("got %d groups, want 14: %v", len(got), got)
+	}
+
+	parents := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("2606:4700::/32"),
+		netip.MustParsePrefix("192.168.0.0/22"),
+	}
+	seen := make(map[string]bool)
+	for _, cidr := range got {
+		if seen[cidr] {
+			t.Errorf("duplicate group %s", cidr)
+		}
+		seen[cidr] = true
+
+		p := netip.MustParsePrefix(cidr)
+		inside := false
+		for _, parent := range parents {
+			inside = inside || parent.Contains(p.Addr())
+		}
+		if !inside {
+			t.Errorf("group %s outside of input", cidr)
+		}
+	}
+}
+
+func TestSplitCount(t *testing.T) {
+	max := strconv.IntSize - 2
+	tests := []struct {
+		ones, bits int
+		want       int
+		wantOK     bool
+	}{
+		{24, 24, 1, true},
+		{16, 24, 256, true},
+		{0, max, 1 << uint(max), true},
+		{0, max + 1, 0, false},
+		{32, 128, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := splitCount(tt.ones, tt.bits)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("splitCount(%d, %d) = %d, %v, want %d, %v", tt.ones, tt.bits, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetLocationMap 获取Cloudflare数据中心位置信息，key 为数据中心代码。
+// 只从网络获取，需要缓存和离线使用时请使用 LoadLocations
+func GetLocationMap() (map[string]*Location, error) {
+	// 设置最大重试次数
+	maxRetries := 5
+	retryDelay := 2 * time.Second
+
+	var lastErr error
+
+	for retry := 0; retry < maxRetries; retry++ {
+		if retry > 0 {
+			time.Sleep(retryDelay)
+		}
+
+		// 创建带超时的客户端
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		resp, err := client.Get("https://speed.cloudflare.com/locations")
+		if err != nil {
+			lastErr = fmt.Errorf("无法获取 locations.json: %v", err)
+			continue // 重试
+		}
+
+		// 确保响应体被关闭
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
+			continue // 重试
+		}
+
+		// 读取整个响应体到内存
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			lastErr = fmt.Errorf("读取响应体失败: %v", err)
+			continue // 重试
+		}
+
+		// 检查响应体是否为空
+		if len(body) == 0 {
+			lastErr = fmt.Errorf("获取到的响应体为空")
+			continue // 重试
+		}
+
+		locationMap, err := parseLocations(body)
+		if err != nil {
+			lastErr = err
+			continue // 重试
+		}
+
+		// 成功获取数据
+		return locationMap, nil
+	}
+
+	// 所有重试都失败
+	return nil, fmt.Errorf("在%d次尝试后仍然失败: %v", maxRetries, lastErr)
+}
+
+// DefaultColoHost 数据中心查询请求默认使用的 Host
+const DefaultColoHost = "cloudflare.com"
+
+// 数据中心查询方式
+const (
+	ColoModeRay   = "ray"   // 发送 HEAD 请求，读取 Cf-Ray 响应头的后缀
+	ColoModeTrace = "trace" // 请求 /cdn-cgi/trace，同时获取出口IP、HTTP和TLS版本等信息
+)
+
+// TraceInfo /cdn-cgi/trace 返回的信息，仅 trace 查询方式
+type TraceInfo struct {
+	Loc  string `json:"loc,omitempty"`  // 本机出口IP所在的国家或地区代码
+	IP   string `json:"ip,omitempty"`   // 本机出口IP
+	HTTP string `json:"http,omitempty"` // 使用的HTTP版本，例如 http/2
+	TLS  string `json:"tls,omitempty"`  // 使用的TLS版本，未使用TLS时为 off
+	Warp string `json:"warp,omitempty"` // 是否通过 WARP 连接
+}
+
+// DataCenterInfo 查询IP所在的数据中心，返回数据中心代码、区域和城市
+func (s *Scanner) DataCenterInfo(ctx context.Context, ip string) (string, string, string) {
+	dataCenter, region, city, _ := s.LookupColo(ctx, ip)
+	return dataCenter, region, city
+}
+
+// LookupColo 按配置的查询方式查询IP所在的数据中心，返回数据中心代码、区域、城市，
+// 以及 trace 方式下 /cdn-cgi/trace 返回的其他信息
+func (s *Scanner) LookupColo(ctx context.Context, ip string) (string, string, string, TraceInfo) {
+	locationMap := s.opts.Locations
+
+	// 使用全局通道控制并发
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		return "Unknown", "", "", TraceInfo{}
+	}
+	defer s.sem.Release(1)
+
+	maxRetries := s.opts.ColoRetries    // 重试次数
+	retryDelay := s.opts.ColoRetryDelay // 添加重试延迟
+
+	// 使用共享的 Transport 对象
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		IdleConnTimeout:   1500 * time.Millisecond, // 超时时间
+		MaxIdleConns:      100,
+		MaxConnsPerHost:   10,
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			ServerName:         s.opts.ColoSNI,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	client := &http.Client{
+		Timeout:   s.opts.ColoTimeout, // 超时时间
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// 确保资源被释放
+	defer transport.CloseIdleConnections()
+
+	for retry := 0; retry <= maxRetries; retry++ {
+		// 添加重试延迟，第一次尝试不延迟
+		if retry > 0 {
+			time.Sleep(retryDelay)
+		}
+
+		var dataCenter string
+		var trace TraceInfo
+		var err error
+		if s.opts.ColoMode == ColoModeTrace {
+			dataCenter, trace, err = s.requestTrace(ctx, client, ip)
+		} else {
+			dataCenter, err = s.requestRay(ctx, client, ip)
+		}
+		if err != nil || dataCenter == "" {
+			continue
+		}
+
+		loc, ok := locationMap[dataCenter]
+		if ok {
+			return dataCenter, loc.Region, loc.City, trace
+		}
+		return dataCenter, "", "", trace
+	}
+
+	return "Unknown", "", "", TraceInfo{}
+}
+
+// 创建发往指定IP的查询请求，未配置路径时使用 defaultPath
+func (s *Scanner) newColoRequest(ctx context.Context, method, ip, defaultPath string) (*http.Request, error) {
+	hostIP := ip
+	if s.opts.ColoPort > 0 {
+		hostIP = net.JoinHostPort(ip, strconv.Itoa(s.opts.ColoPort))
+	} else if !strings.Contains(ip, ".") {
+		hostIP = "[" + ip + "]"
+	}
+	path := s.opts.ColoPath
+	if path == "" {
+		path = defaultPath
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.opts.ColoScheme+"://"+hostIP+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = s.opts.ColoHost
+	req.Close = true
+	return req, nil
+}
+
+// 数据中心缓存的 key，由CIDR、查询方式和请求目标组成，
+// 使不同方式查询到的结果不会混用
+func (s *Scanner) coloCacheKey(cidr string) string {
+	return strings.Join([]string{
+		cidr,
+		s.opts.ColoMode,
+		s.opts.ColoScheme,
+		s.opts.ColoHost,
+		s.opts.ColoSNI,
+		strconv.Itoa(s.opts.ColoPort),
+		s.opts.ColoPath,
+	}, " ")
+}
+
+// 通过 Cf-Ray 响应头查询数据中心
+func (s *Scanner) requestRay(ctx context.Context, client *http.Client, ip string) (string, error) {
+	req, err := s.newColoRequest(ctx, http.MethodHead, ip, "/")
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	cfRay := resp.Header.Get("Cf-Ray")
+	resp.Body.Close()
+
+	lastDashIndex := strings.LastIndex(cfRay, "-")
+	if lastDashIndex == -1 {
+		return "", fmt.Errorf("无效的 Cf-Ray: %q", cfRay)
+	}
+	return cfRay[lastDashIndex+1:], nil
+}
+
+// 通过 /cdn-cgi/trace 查询数据中心和其他信息
+func (s *Scanner) requestTrace(ctx context.Context, client *http.Client, ip string) (string, TraceInfo, error) {
+	req, err := s.newColoRequest(ctx, http.MethodGet, ip, "/cdn-cgi/trace")
+	if err != nil {
+		return "", TraceInfo{}, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", TraceInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", TraceInfo{}, fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
+	}
+
+	// trace 的内容只有几百字节，限制读取大小避免异常响应占用内存
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16*1024))
+	if err != nil {
+		return "", TraceInfo{}, err
+	}
+
+	dataCenter, trace := parseTrace(string(body))
+	return dataCenter, trace, nil
+}
+
+// 解析 /cdn-cgi/trace 的 key=value 格式内容，返回数据中心代码和其他信息
+func parseTrace(body string) (string, TraceInfo) {
+	var dataCenter string
+	var trace TraceInfo
+	for _, line := range strings.Split(body, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "colo":
+			dataCenter = value
+		case "loc":
+			trace.Loc = value
+		case "ip":
+			trace.IP = value
+		case "http":
+			trace.HTTP = value
+		case "tls":
+			trace.TLS = value
+		case "warp":
+			trace.Warp = value
+		}
+	}
+	return dataCenter, trace
+}
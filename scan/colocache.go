@@ -0,0 +1,130 @@
+package scan
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 数据中心信息的来源
+const (
+	ColoSourceCached = "cached" // 来自 ColoCache
+	ColoSourceLive   = "live"   // 本次运行实时查询
+)
+
+// ColoCache 按CIDR和查询方式保存数据中心查询结果，跨运行复用，避免每次都重新查询。
+// 查询方式或请求目标不同时分别缓存，出口IP与本机网络有关，不写入缓存
+type ColoCache struct {
+	path    string
+	maxAge  time.Duration
+	mutex   sync.Mutex
+	entries map[string]coloCacheEntry
+	dirty   bool
+}
+
+// 缓存文件中的一条记录
+type coloCacheEntry struct {
+	DataCenter string    `json:"colo"`
+	Region     string    `json:"region"`
+	City       string    `json:"city"`
+	Trace      TraceInfo `json:"trace"`
+	Time       time.Time `json:"time"` // 查询时间
+}
+
+// DefaultColoCacheFile 返回用户缓存目录下的数据中心缓存文件路径
+func DefaultColoCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cfspeed", "colo.json"), nil
+}
+
+// LoadColoCache 从 path 读取数据中心缓存，文件不存在时返回空缓存。
+// 查询时间超过 maxAge 的记录不会被使用，并在保存时删除
+func LoadColoCache(path string, maxAge time.Duration) (*ColoCache, error) {
+	c := &ColoCache{
+		path:    path,
+		maxAge:  maxAge,
+		entries: make(map[string]coloCacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Len 返回未过期的记录数量
+func (c *ColoCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := 0
+	for _, entry := range c.entries {
+		if !c.expired(entry) {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *ColoCache) expired(entry coloCacheEntry) bool {
+	return time.Since(entry.Time) > c.maxAge
+}
+
+// 查询未过期的数据中心信息，key 由 Scanner.coloCacheKey 生成
+func (c *ColoCache) lookup(key string) (coloCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.expired(entry) {
+		return coloCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// 记录实时查询到的数据中心信息，出口IP不写入缓存
+func (c *ColoCache) store(key string, entry coloCacheEntry) {
+	entry.Time = time.Now()
+	entry.Trace.IP = ""
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = entry
+	c.dirty = true
+}
+
+// Save 删除过期的记录后将缓存写入文件
+func (c *ColoCache) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	for cidr, entry := range c.entries {
+		if c.expired(entry) {
+			delete(c.entries, cidr)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, c.entries); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,124 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultDownloadURL 默认的下载测速地址
+const DefaultDownloadURL = "https://speed.cloudflare.com/__down?bytes=50000000"
+
+// SpeedTest 通过指定IP下载文件测量下载速度，Host 和 SNI 取自 URL
+type SpeedTest struct {
+	URL      string        // 下载地址，为空时使用 DefaultDownloadURL
+	Duration time.Duration // 单个IP的下载时长上限，为 0 时使用 10 秒
+	Logf     LogFunc
+}
+
+// Run 依次对前 count 个结果进行下载测速，将速度写入 DownloadSpeed，
+// count 大于结果数量时测试全部结果
+func (t *SpeedTest) Run(ctx context.Context, results []TestResult, count int) {
+	if count > len(results) {
+		count = len(results)
+	}
+
+	for i := 0; i < count; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		result := &results[i]
+		if result.IP == "" {
+			continue
+		}
+
+		speed, err := t.Measure(ctx, result.IP)
+		if err != nil {
+			t.Logf.printf("下载测速 %s (%s) 失败: %v\n", result.IP, result.CIDR, err)
+			continue
+		}
+		result.DownloadSpeed = speed
+		t.Logf.printf("下载测速 %s (%s): %.2f MiB/s\n", result.IP, result.CIDR, speed)
+	}
+}
+
+// Measure 通过指定IP下载文件，返回下载速度(MiB/s)
+// 达到时长上限时按已下载的数据计算速度
+func (t *SpeedTest) Measure(ctx context.Context, ip string) (float64, error) {
+	rawURL := t.URL
+	if rawURL == "" {
+		rawURL = DefaultDownloadURL
+	}
+	duration := t.Duration
+	if duration <= 0 {
+		duration = 10 * time.Second
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+
+	// 端口取自 URL，未指定时按协议使用默认端口
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(ip, port)
+
+	// 所有连接都发往指定IP，Host 和 SNI 保持 URL 中的域名
+	dialer := &net.Dialer{Timeout: duration}
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSClientConfig: &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: true,
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
+	}
+
+	// 读取到结束或超时，超时前已下载的数据仍计入速度
+	written, err := io.Copy(io.Discard, resp.Body)
+	elapsed := time.Since(start)
+	if written == 0 {
+		if err == nil {
+			err = fmt.Errorf("未下载到数据")
+		}
+		return 0, err
+	}
+
+	return float64(written) / 1024 / 1024 / elapsed.Seconds(), nil
+}
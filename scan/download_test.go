@@ -0,0 +1,127 @@
+package scan
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 启动本地测速服务器，返回使用域名 example.com 和服务器端口的下载地址
+func newDownloadServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "http://example.com:" + port + "/__down"
+}
+
+func TestSpeedTestMeasure(t *testing.T) {
+	payload := make([]byte, 1<<20)
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		wantErr   bool
+		wantSpeed bool
+	}{
+		{
+			name: "success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.Host, "example.com:") {
+					http.Error(w, "bad host", http.StatusBadRequest)
+					return
+				}
+				w.Write(payload)
+			},
+			wantSpeed: true,
+		},
+		{
+			name: "non-200",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "not found", http.StatusNotFound)
+			},
+			wantErr: true,
+		},
+		{
+			name: "timeout before response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			wantErr: true,
+		},
+		{
+			name: "timeout during body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(payload)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+			wantSpeed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &SpeedTest{
+				URL:      newDownloadServer(t, tt.handler),
+				Duration: 300 * time.Millisecond,
+			}
+			speed, err := st.Measure(context.Background(), "127.0.0.1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (speed > 0) != tt.wantSpeed {
+				t.Errorf("speed = %v, want speed > 0: %v", speed, tt.wantSpeed)
+			}
+		})
+	}
+}
+
+func TestSpeedTestRun(t *testing.T) {
+	url := newDownloadServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 64<<10))
+	})
+	failURL := newDownloadServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	results := []TestResult{
+		{CIDR: "127.0.0.0/24", IP: "127.0.0.1"},
+		{CIDR: "10.0.0.0/24"},
+		{CIDR: "127.0.0.0/24", IP: "127.0.0.1"},
+	}
+	st := &SpeedTest{URL: url, Duration: time.Second}
+	st.Run(context.Background(), results, 2)
+	if results[0].DownloadSpeed <= 0 {
+		t.Errorf("results[0].DownloadSpeed = %v, want > 0", results[0].DownloadSpeed)
+	}
+	if results[1].DownloadSpeed != 0 {
+		t.Errorf("result without IP was tested: %v", results[1].DownloadSpeed)
+	}
+	if results[2].DownloadSpeed != 0 {
+		t.Errorf("result beyond count was tested: %v", results[2].DownloadSpeed)
+	}
+
+	failed := []TestResult{{CIDR: "127.0.0.0/24", IP: "127.0.0.1"}}
+	st = &SpeedTest{URL: failURL, Duration: time.Second}
+	st.Run(context.Background(), failed, 10)
+	if failed[0].DownloadSpeed != 0 {
+		t.Errorf("failed download recorded speed %v", failed[0].DownloadSpeed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := []TestResult{{CIDR: "127.0.0.0/24", IP: "127.0.0.1"}}
+	(&SpeedTest{URL: url}).Run(ctx, canceled, 1)
+	if canceled[0].DownloadSpeed != 0 {
+		t.Errorf("canceled run recorded speed %v", canceled[0].DownloadSpeed)
+	}
+}
@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ExcludeCIDRs 从 cidrs 中去掉 excludes 覆盖的地址，返回剩余的CIDR和被改动的CIDR数量。
+// excludes 中的每一项可以是CIDR或单个IP；与排除项部分重叠的CIDR会拆分为不包含排除地址的子网，
+// 完全被覆盖的CIDR会被删除，未重叠的CIDR保持原样。cidrs 中无法解析的项原样保留
+func ExcludeCIDRs(cidrs, excludes []string) ([]string, int, error) {
+	var excludePrefixes []netip.Prefix
+	for _, item := range excludes {
+		prefix, err := parsePrefix(item)
+		if err != nil {
+			return nil, 0, fmt.Errorf("无效的排除项 %q: %v", item, err)
+		}
+		excludePrefixes = append(excludePrefixes, prefix)
+	}
+	if len(excludePrefixes) == 0 {
+		return cidrs, 0, nil
+	}
+
+	var result []string
+	changed := 0
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			result = append(result, cidr)
+			continue
+		}
+
+		remaining := []netip.Prefix{prefix}
+		for _, exclude := range excludePrefixes {
+			var next []netip.Prefix
+			for _, p := range remaining {
+				next = append(next, subtractPrefix(p, exclude)...)
+			}
+			remaining = next
+		}
+
+		if len(remaining) == 1 && remaining[0] == prefix {
+			result = append(result, cidr)
+			continue
+		}
+		changed++
+		for _, p := range remaining {
+			result = append(result, p.String())
+		}
+	}
+	return result, changed, nil
+}
+
+// 解析CIDR或单个IP，单个IP视为 /32 或 /128，返回去掉主机位的前缀
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// 从 p 中减去 exclude，返回剩余部分的子网
+func subtractPrefix(p, exclude netip.Prefix) []netip.Prefix {
+	if p.Addr().Is4() != exclude.Addr().Is4() || !p.Overlaps(exclude) {
+		return []netip.Prefix{p}
+	}
+	// exclude 覆盖了整个 p
+	if exclude.Bits() <= p.Bits() {
+		return nil
+	}
+
+	// p 包含 exclude，逐级对半拆分，保留不包含 exclude 的一半
+	var result []netip.Prefix
+	for p.Bits() < exclude.Bits() {
+		low, high := splitPrefix(p)
+		if low.Contains(exclude.Addr()) {
+			result = append(result, high)
+			p = low
+		} else {
+			result = append(result, low)
+			p = high
+		}
+	}
+	return result
+}
+
+// 将前缀拆分为两个前缀长度加一的子网
+func splitPrefix(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits()
+	low := netip.PrefixFrom(p.Addr(), bits+1)
+
+	addr := p.Addr().AsSlice()
+	addr[bits/8] |= 0x80 >> (bits % 8)
+	highAddr, _ := netip.AddrFromSlice(addr)
+	high := netip.PrefixFrom(highAddr, bits+1)
+
+	return low, high
+}
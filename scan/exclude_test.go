@@ -0,0 +1,54 @@
+package scan
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestSubtractPrefix(t *testing.T) {
+	tests := []struct {
+		p, exclude string
+		want       []string
+	}{
+		{"10.0.0.0/24", "10.0.1.0/24", []string{"10.0.0.0/24"}},
+		{"10.0.0.0/24", "10.0.0.0/16", nil},
+		{"10.0.0.0/24", "10.0.0.0/24", nil},
+		{"10.0.0.0/22", "10.0.1.0/24", []string{"10.0.2.0/23", "10.0.0.0/24"}},
+		{"10.0.0.0/30", "10.0.0.2/32", []string{"10.0.0.0/31", "10.0.0.3/32"}},
+		{"2606:4700::/32", "2606:4700::/33", []string{"2606:4700:8000::/33"}},
+		{"10.0.0.0/8", "2606:4700::/32", []string{"10.0.0.0/8"}},
+	}
+
+	for _, tt := range tests {
+		got := subtractPrefix(netip.MustParsePrefix(tt.p), netip.MustParsePrefix(tt.exclude))
+		var gotStrings []string
+		for _, p := range got {
+			gotStrings = append(gotStrings, p.String())
+		}
+		if !reflect.DeepEqual(gotStrings, tt.want) {
+			t.Errorf("subtractPrefix(%s, %s) = %v, want %v", tt.p, tt.exclude, gotStrings, tt.want)
+		}
+	}
+}
+
+func TestExcludeCIDRs(t *testing.T) {
+	got, changed, err := ExcludeCIDRs(
+		[]string{"10.0.0.0/23", "192.168.0.0/24", "172.16.0.0/24", "not-a-cidr"},
+		[]string{"10.0.1.0/24", "172.16.0.0/16"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/24", "192.168.0.0/24", "not-a-cidr"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if changed != 2 {
+		t.Errorf("changed = %d, want 2", changed)
+	}
+
+	if _, _, err := ExcludeCIDRs([]string{"10.0.0.0/24"}, []string{"bad"}); err == nil {
+		t.Error("invalid exclude: want error")
+	}
+}
@@ -0,0 +1,194 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+)
+
+// GenerateIPFile 根据结果中的CIDR生成IP列表并写入文件
+// ipv4Mode 可为 all 或数量，ipv6Mode 为数量，留空表示不生成该类型。
+// rng 用于生成随机IP，为 nil 时使用当前时间作为种子
+func GenerateIPFile(results []TestResult, ipv4Mode, ipv6Mode, filename string, rng *rand.Rand, logf LogFunc) error {
+	// 检查是否至少指定了一种IP类型
+	if ipv4Mode == "" && ipv6Mode == "" {
+		return fmt.Errorf("必须至少指定 -useip4 或 -useip6 参数")
+	}
+	rng = ensureRand(rng)
+
+	var ipList []string
+
+	// 检查是否有IPv4和IPv6的CIDR
+	hasIPv4CIDR := false
+	hasIPv6CIDR := false
+
+	// 根据需要检查的IP类型进行判断
+	needCheckIPv4 := ipv4Mode != ""
+	needCheckIPv6 := ipv6Mode != ""
+
+	for _, result := range results {
+		_, ipNet, err := net.ParseCIDR(result.CIDR)
+		if err != nil {
+			continue
+		}
+
+		// 分别判断IPv4和IPv6
+		if needCheckIPv4 && !hasIPv4CIDR && ipNet.IP.To4() != nil {
+			hasIPv4CIDR = true
+		}
+
+		if needCheckIPv6 && !hasIPv6CIDR && ipNet.IP.To4() == nil && ipNet.IP.To16() != nil {
+			hasIPv6CIDR = true
+		}
+
+		// 如果需要检查的类型都已找到，就可以提前结束检查
+		if (!needCheckIPv4 || hasIPv4CIDR) && (!needCheckIPv6 || hasIPv6CIDR) {
+			break
+		}
+	}
+
+	// 处理 IPv4
+	if ipv4Mode != "" && hasIPv4CIDR {
+		ipv4Count := 0
+		ipv4Limit := 1000000 // 设置IPv4上限为100万
+
+		if ipv4Mode == "all" {
+			// 遍历每个CIDR生成完整的IP列表
+			for _, prefix := range resultPrefixes(results, true) {
+				count, ok := hostCount(prefix)
+				if !ok || count > ipv4Limit-ipv4Count {
+					count = ipv4Limit - ipv4Count
+				}
+				for i := 0; i < count; i++ {
+					ipList = append(ipList, hostAt(prefix, i).String())
+				}
+				ipv4Count += count
+
+				// 检查是否达到上限
+				if ipv4Count >= ipv4Limit {
+					logf.printf("已达到IPv4生成上限 %d 个\n", ipv4Limit)
+					break
+				}
+			}
+		} else if count, err := strconv.Atoi(ipv4Mode); err == nil && count > 0 {
+			targetCount := count
+			if targetCount > ipv4Limit {
+				targetCount = ipv4Limit
+				logf.printf("IPv4生成数量已限制为 %d 个\n", ipv4Limit)
+			}
+
+			ips := sampleIPList(rng, resultPrefixes(results, true), targetCount)
+			if len(ips) < targetCount {
+				logf.printf("警告: 可用IPv4地址总数(%d)小于请求数量(%d)\n", len(ips), targetCount)
+			}
+			ipList = append(ipList, ips...)
+		}
+	}
+
+	// 处理 IPv6
+	if ipv6Mode != "" && hasIPv6CIDR {
+		ipv6Limit := 1000000 // 设置IPv6上限为100万
+
+		if count, err := strconv.Atoi(ipv6Mode); err == nil && count > 0 {
+			targetCount := count
+			if targetCount > ipv6Limit {
+				targetCount = ipv6Limit
+				logf.printf("IPv6生成数量已限制为 %d 个\n", ipv6Limit)
+			}
+
+			ips := sampleIPList(rng, resultPrefixes(results, false), targetCount)
+			if len(ips) < targetCount {
+				logf.printf("警告: 可用IPv6地址总数(%d)小于请求数量(%d)\n", len(ips), targetCount)
+			}
+			ipList = append(ipList, ips...)
+
+			if len(ips) > 0 {
+				logf.printf("成功生成 %d 个IPv6地址\n", len(ips))
+			}
+		}
+	}
+
+	// 写入文件
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, ip := range ipList {
+		_, err := writer.WriteString(ip + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// 返回结果中IPv4或IPv6的CIDR
+func resultPrefixes(results []TestResult, ipv4 bool) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, result := range results {
+		prefix, err := parsePrefix(result.CIDR)
+		if err != nil || prefix.Addr().Is4() != ipv4 {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// 从 prefixes 中选取最多 target 个不重复的IP，各CIDR轮流分配数量，
+// IP不足的CIDR使用其全部IP，输出顺序与轮流分配的顺序一致
+func sampleIPList(rng *rand.Rand, prefixes []netip.Prefix, target int) []string {
+	// 每个CIDR最多可分配的数量
+	limits := make([]int, len(prefixes))
+	active := make([]int, 0, len(prefixes))
+	for i, prefix := range prefixes {
+		limit, ok := hostCount(prefix)
+		if !ok || limit > target {
+			limit = target
+		}
+		limits[i] = limit
+		if limit > 0 {
+			active = append(active, i)
+		}
+	}
+
+	// 轮流为每个CIDR分配一个IP，分配满的CIDR不再参与
+	counts := make([]int, len(prefixes))
+	order := make([]int, 0, target)
+	for len(order) < target && len(active) > 0 {
+		next := active[:0]
+		for _, i := range active {
+			if len(order) == target {
+				break
+			}
+			counts[i]++
+			order = append(order, i)
+			if counts[i] < limits[i] {
+				next = append(next, i)
+			}
+		}
+		active = next
+	}
+
+	// 在每个CIDR中不重复地选取分配的数量，再按分配顺序输出
+	addrs := make([][]netip.Addr, len(prefixes))
+	for i, prefix := range prefixes {
+		if counts[i] > 0 {
+			addrs[i] = sampleAddrs(rng, prefix, counts[i])
+		}
+	}
+	ipList := make([]string, 0, len(order))
+	used := make([]int, len(prefixes))
+	for _, i := range order {
+		ipList = append(ipList, addrs[i][used[i]].String())
+		used[i]++
+	}
+	return ipList
+}
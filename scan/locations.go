@@ -0,0 +1,134 @@
+package scan
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 编译时内置的数据中心位置信息快照，联网获取和缓存都不可用时使用。
+// 在 scan 目录执行 go generate 从 https://speed.cloudflare.com/locations 重新生成
+//
+//go:generate curl -fsSL -o locations.json https://speed.cloudflare.com/locations
+//go:embed locations.json
+var embeddedLocations []byte
+
+// DefaultLocationCacheTTL 数据中心位置信息缓存的默认有效期
+const DefaultLocationCacheTTL = 7 * 24 * time.Hour
+
+// LocationOptions 数据中心位置信息的获取方式
+type LocationOptions struct {
+	File      string        // 本地JSON文件，设置后只从该文件读取
+	CacheFile string        // 缓存文件，为空时使用 DefaultLocationCacheFile
+	CacheTTL  time.Duration // 缓存有效期，未过期时不联网获取，为 0 时使用 DefaultLocationCacheTTL
+	Logf      LogFunc
+}
+
+// DefaultLocationCacheFile 返回用户缓存目录下的缓存文件路径
+func DefaultLocationCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cfspeed", "locations.json"), nil
+}
+
+// LoadLocations 获取数据中心位置信息，key 为数据中心代码。
+// 指定 File 时只读取该文件；否则优先使用未过期的缓存，其次联网获取并更新缓存，
+// 获取失败时依次使用过期的缓存和内置快照
+func LoadLocations(opts LocationOptions) (map[string]*Location, error) {
+	if opts.File != "" {
+		return readLocationFile(opts.File)
+	}
+
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = DefaultLocationCacheTTL
+	}
+	cacheFile := opts.CacheFile
+	if cacheFile == "" {
+		var err error
+		if cacheFile, err = DefaultLocationCacheFile(); err != nil {
+			opts.Logf.printf("无法确定缓存目录，将不缓存数据中心位置信息: %v\n", err)
+		}
+	}
+
+	// 读取缓存，未过期时直接使用
+	var cached map[string]*Location
+	if cacheFile != "" {
+		if info, err := os.Stat(cacheFile); err == nil {
+			cached, err = readLocationFile(cacheFile)
+			if err != nil {
+				opts.Logf.printf("读取数据中心位置信息缓存失败: %v\n", err)
+			} else if time.Since(info.ModTime()) < opts.CacheTTL {
+				return cached, nil
+			}
+		}
+	}
+
+	locationMap, err := GetLocationMap()
+	if err == nil {
+		if cacheFile != "" {
+			if err := saveLocationCache(cacheFile, locationMap); err != nil {
+				opts.Logf.printf("保存数据中心位置信息缓存失败: %v\n", err)
+			}
+		}
+		return locationMap, nil
+	}
+
+	// 获取失败时使用过期的缓存或内置快照
+	if cached != nil {
+		opts.Logf.printf("获取数据中心位置信息失败，使用过期的缓存 %s: %v\n", cacheFile, err)
+		return cached, nil
+	}
+	opts.Logf.printf("获取数据中心位置信息失败，使用内置数据: %v\n", err)
+	return parseLocations(embeddedLocations)
+}
+
+// 读取JSON格式的数据中心位置信息文件
+func readLocationFile(filename string) (map[string]*Location, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseLocations(data)
+}
+
+// 解析 https://speed.cloudflare.com/locations 格式的数据
+func parseLocations(data []byte) (map[string]*Location, error) {
+	var locations []Location
+	if err := json.Unmarshal(data, &locations); err != nil {
+		return nil, fmt.Errorf("无法解析JSON: %v", err)
+	}
+
+	// 检查解析后的数据是否为空
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("解析后的数据中心列表为空")
+	}
+
+	// 构造 location 映射，key 为数据中心代码，使用指针
+	locationMap := make(map[string]*Location)
+	for i := range locations {
+		locationMap[locations[i].Iata] = &locations[i]
+	}
+	return locationMap, nil
+}
+
+// 将数据中心位置信息按代码排序后写入缓存文件
+func saveLocationCache(filename string, locationMap map[string]*Location) error {
+	locations := make([]*Location, 0, len(locationMap))
+	for _, loc := range locationMap {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].Iata < locations[j].Iata
+	})
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, locations)
+}
@@ -0,0 +1,15 @@
+package scan
+
+import "testing"
+
+func TestEmbeddedLocations(t *testing.T) {
+	locationMap, err := parseLocations(embeddedLocations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, loc := range locationMap {
+		if code == "" || loc.Region == "" || loc.City == "" {
+			t.Errorf("incomplete location %q: %+v", code, loc)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Attempt 一次探测的结果，Err 不为 nil 时表示本次探测失败
+type Attempt struct {
+	Latency   time.Duration // TCP连接耗时
+	Handshake time.Duration // TLS握手耗时，仅 tls 模式
+	Err       error
+}
+
+// Prober 探测方式，对单个IP进行 count 次探测并返回每次的结果
+type Prober interface {
+	Probe(ctx context.Context, ip string, port, count int) []Attempt
+}
+
+// 可通过 -mode 选择的探测方式
+const (
+	ModeTCP = "tcp"
+	ModeTLS = "tls"
+)
+
+// DefaultSNI tls 模式默认使用的 SNI
+const DefaultSNI = "speed.cloudflare.com"
+
+// ProberConfig 创建 Prober 时使用的参数
+type ProberConfig struct {
+	Timeout  time.Duration // 单次探测超时，为 0 时使用 1 秒
+	Interval time.Duration // 同一IP两次探测之间的间隔
+	SNI      string        // tls 模式使用的 SNI，为空时使用 DefaultSNI
+}
+
+// NewProber 根据探测方式名称创建 Prober
+func NewProber(mode string, cfg ProberConfig) (Prober, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.SNI == "" {
+		cfg.SNI = DefaultSNI
+	}
+
+	switch mode {
+	case "", ModeTCP:
+		return &TCPProber{Timeout: cfg.Timeout, Interval: cfg.Interval}, nil
+	case ModeTLS:
+		return &TLSProber{Timeout: cfg.Timeout, Interval: cfg.Interval, SNI: cfg.SNI}, nil
+	default:
+		return nil, fmt.Errorf("不支持的探测方式: %s", mode)
+	}
+}
+
+// TCPProber 以TCP连接建立时间作为延迟
+type TCPProber struct {
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// Probe 依次建立 count 次TCP连接并记录耗时
+func (p *TCPProber) Probe(ctx context.Context, ip string, port, count int) []Attempt {
+	dialer := net.Dialer{Timeout: p.Timeout}
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	attempts := make([]Attempt, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 && !waitInterval(ctx, p.Interval) {
+			break
+		}
+
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			attempts = append(attempts, Attempt{Err: err})
+			continue
+		}
+		latency := time.Since(start)
+		conn.Close()
+
+		attempts = append(attempts, Attempt{Latency: latency})
+	}
+	return attempts
+}
+
+// TLSProber 分别记录TCP连接和TLS握手耗时，更接近HTTPS客户端的实际体验
+type TLSProber struct {
+	Timeout  time.Duration
+	Interval time.Duration
+	SNI      string
+}
+
+// Probe 依次建立 count 次TLS连接，TCP连接或握手失败都计为丢包
+func (p *TLSProber) Probe(ctx context.Context, ip string, port, count int) []Attempt {
+	dialer := net.Dialer{Timeout: p.Timeout}
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	config := &tls.Config{
+		ServerName: p.SNI,
+		// 只关心握手耗时，不校验证书
+		InsecureSkipVerify: true,
+	}
+
+	attempts := make([]Attempt, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 && !waitInterval(ctx, p.Interval) {
+			break
+		}
+
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			attempts = append(attempts, Attempt{Err: err})
+			continue
+		}
+		latency := time.Since(start)
+
+		// 握手与TCP连接使用相同的超时
+		conn.SetDeadline(time.Now().Add(p.Timeout))
+		tlsConn := tls.Client(conn, config)
+		start = time.Now()
+		err = tlsConn.HandshakeContext(ctx)
+		handshake := time.Since(start)
+		tlsConn.Close()
+		if err != nil {
+			attempts = append(attempts, Attempt{Latency: latency, Err: err})
+			continue
+		}
+
+		attempts = append(attempts, Attempt{Latency: latency, Handshake: handshake})
+	}
+	return attempts
+}
+
+// 等待探测间隔，上下文取消时返回 false
+func waitInterval(ctx context.Context, interval time.Duration) bool {
+	if interval <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,75 @@
+package scan
+
+import (
+	"math/rand"
+	"net/netip"
+	"time"
+)
+
+// 返回 rng，为 nil 时返回以当前时间为种子的随机数生成器
+func ensureRand(rng *rand.Rand) *rand.Rand {
+	if rng == nil {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rng
+}
+
+// 前缀中的IP数量，超出 int 范围时返回 false
+func hostCount(p netip.Prefix) (int, bool) {
+	return pow2(p.Addr().BitLen() - p.Bits())
+}
+
+// 返回前缀中第 index 个IP
+func hostAt(p netip.Prefix, index int) netip.Addr {
+	return subPrefixAt(p, p.Addr().BitLen(), uint64(index)).Addr()
+}
+
+// 从前缀中不重复地随机选取 n 个IP，前缀中的IP不足 n 个时按顺序返回全部IP
+func sampleAddrs(rng *rand.Rand, p netip.Prefix, n int) []netip.Addr {
+	if n <= 0 {
+		return nil
+	}
+	count, ok := hostCount(p)
+	if ok && count <= n {
+		addrs := make([]netip.Addr, count)
+		for i := range addrs {
+			addrs[i] = hostAt(p, i)
+		}
+		return addrs
+	}
+
+	addrs := make([]netip.Addr, 0, n)
+	if ok {
+		for _, index := range sampleIndexes(rng, count, n) {
+			addrs = append(addrs, hostAt(p, index))
+		}
+		return addrs
+	}
+
+	// IP数量过大，直接随机生成主机位，重复的概率可以忽略，重复时重新生成
+	chosen := make(map[netip.Addr]bool, n)
+	for len(addrs) < n {
+		addr := randomSubPrefix(rng, p, p.Addr().BitLen()).Addr()
+		if chosen[addr] || addr.IsUnspecified() {
+			continue
+		}
+		chosen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// 使用 Floyd 算法从 [0, count) 中不重复地随机选取 n 个序号，要求 n <= count
+func sampleIndexes(rng *rand.Rand, count, n int) []int {
+	indexes := make([]int, 0, n)
+	chosen := make(map[int]bool, n)
+	for j := count - n; j < count; j++ {
+		t := rng.Intn(j + 1)
+		if chosen[t] {
+			t = j
+		}
+		chosen[t] = true
+		indexes = append(indexes, t)
+	}
+	return indexes
+}
@@ -0,0 +1,169 @@
+package scan
+
+import (
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type hostCountTest struct {
+	prefix string
+	want   int
+	wantOK bool
+}
+
+func TestHostCount(t *testing.T) {
+	tests := []hostCountTest{
+		{"1.1.1.1/32", 1, true},
+		{"1.1.1.0/31", 2, true},
+		{"1.1.1.0/30", 4, true},
+		{"1.1.1.0/24", 256, true},
+		{"2606:4700::/127", 2, true},
+		{"2606:4700::/120", 256, true},
+		{"2606:4700::/48", 0, false},
+	}
+	// /80 的IPv6前缀有 2^48 个IP，只在 64 位平台上可以用 int 表示
+	hostBits := 48
+	if strconv.IntSize == 64 {
+		tests = append(tests, hostCountTest{"2606:4700::/80", 1 << uint(hostBits), true})
+	} else {
+		tests = append(tests, hostCountTest{"2606:4700::/80", 0, false})
+	}
+
+	for _, tt := range tests {
+		got, ok := hostCount(netip.MustParsePrefix(tt.prefix))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("hostCount(%s) = %d, %v, want %d, %v", tt.prefix, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSampleIndexes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct{ count, n int }{
+		{1, 1},
+		{10, 10},
+		{10, 3},
+		{1000, 999},
+		{1 << 30, 100},
+	}
+
+	for _, tt := range tests {
+		got := sampleIndexes(rng, tt.count, tt.n)
+		if len(got) != tt.n {
+			t.Fatalf("sampleIndexes(%d, %d) returned %d indexes", tt.count, tt.n, len(got))
+		}
+		seen := make(map[int]bool)
+		for _, index := range got {
+			if index < 0 || index >= tt.count {
+				t.Errorf("sampleIndexes(%d, %d): index %d out of range", tt.count, tt.n, index)
+			}
+			if seen[index] {
+				t.Errorf("sampleIndexes(%d, %d): duplicate index %d", tt.count, tt.n, index)
+			}
+			seen[index] = true
+		}
+	}
+}
+
+func TestSampleAddrs(t *testing.T) {
+	tests := []struct {
+		prefix string
+		n      int
+		want   []string // 为空时只检查数量、范围和重复
+		wantN  int
+	}{
+		{"1.1.1.1/32", 2, []string{"1.1.1.1"}, 1},
+		{"1.1.1.0/31", 4, []string{"1.1.1.0", "1.1.1.1"}, 2},
+		{"1.1.1.0/30", 4, []string{"1.1.1.0", "1.1.1.1", "1.1.1.2", "1.1.1.3"}, 4},
+		{"1.1.1.0/24", 256, nil, 256},
+		{"1.1.1.0/24", 10, nil, 10},
+		{"2606:4700::/126", 8, []string{"2606:4700::", "2606:4700::1", "2606:4700::2", "2606:4700::3"}, 4},
+		{"2606:4700::/64", 100, nil, 100},
+		{"2606:4700::/32", 100, nil, 100},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		prefix := netip.MustParsePrefix(tt.prefix)
+		got := sampleAddrs(rng, prefix, tt.n)
+		if len(got) != tt.wantN {
+			t.Fatalf("sampleAddrs(%s, %d) returned %d addresses", tt.prefix, tt.n, len(got))
+		}
+		if tt.want != nil {
+			var gotStrings []string
+			for _, addr := range got {
+				gotStrings = append(gotStrings, addr.String())
+			}
+			if !reflect.DeepEqual(gotStrings, tt.want) {
+				t.Errorf("sampleAddrs(%s, %d) = %v, want %v", tt.prefix, tt.n, gotStrings, tt.want)
+			}
+		}
+
+		seen := make(map[netip.Addr]bool)
+		for _, addr := range got {
+			if !prefix.Contains(addr) {
+				t.Errorf("sampleAddrs(%s): %s outside of prefix", tt.prefix, addr)
+			}
+			if seen[addr] {
+				t.Errorf("sampleAddrs(%s): duplicate address %s", tt.prefix, addr)
+			}
+			seen[addr] = true
+		}
+	}
+}
+
+func TestGenerateIPFile(t *testing.T) {
+	results := []TestResult{
+		{CIDR: "10.0.0.0/30"},
+		{CIDR: "10.0.1.0/31"},
+		{CIDR: "10.0.2.0/24"},
+	}
+	tests := []struct {
+		name  string
+		ipv4  string
+		wantN int
+	}{
+		{"all", "all", 4 + 2 + 256},
+		{"count", "100", 100},
+		{"more than available", "1000", 4 + 2 + 256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "ip.txt")
+			rng := rand.New(rand.NewSource(1))
+			if err := GenerateIPFile(results, tt.ipv4, "", filename, rng, nil); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ips := strings.Fields(string(data))
+			if len(ips) != tt.wantN {
+				t.Errorf("got %d IPs, want %d", len(ips), tt.wantN)
+			}
+			seen := make(map[string]bool)
+			for _, ip := range ips {
+				if seen[ip] {
+					t.Errorf("duplicate IP %s", ip)
+				}
+				seen[ip] = true
+			}
+			if tt.ipv4 == "all" {
+				for _, ip := range []string{"10.0.0.0", "10.0.0.3", "10.0.2.0", "10.0.2.255"} {
+					if !seen[ip] {
+						t.Errorf("%s missing from full list", ip)
+					}
+				}
+			}
+		})
+	}
+}
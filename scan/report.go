@@ -0,0 +1,33 @@
+package scan
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Report JSON 格式的测速结果文档，包含本次运行的元数据
+type Report struct {
+	Tool      string            `json:"tool"`
+	Version   string            `json:"version"`
+	StartTime time.Time         `json:"start_time"`
+	EndTime   time.Time         `json:"end_time"`
+	Sources   []string          `json:"sources"` // CIDR 来源，URL、文件路径或命令行指定的CIDR
+	Flags     map[string]string `json:"flags"`   // 运行时的全部参数
+	Seed      int64             `json:"seed"`    // 随机种子，使用 -seed 指定相同的种子可以测试相同的IP
+	Results   []TestResult      `json:"results"`
+}
+
+// ReadReport 读取 JSON 格式的测速结果文档
+func ReadReport(filename string) (*Report, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,223 @@
+package scan
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ----------------------- 数据类型定义 -----------------------
+
+// TestResult 单个IP或CIDR的测试结果，CIDR结果的 IP 为其中延迟最低的IP
+type TestResult struct {
+	IP            string
+	CIDR          string
+	DataCenter    string
+	Region        string
+	City          string
+	AvgLatency    time.Duration // 平均延迟，tls 模式下为TCP连接耗时
+	TLSLatency    time.Duration // TLS握手平均耗时，仅 tls 模式
+	Latency       LatencyStats  // 延迟分布，tls 模式下包含握手耗时
+	LossRate      float64
+	DownloadSpeed float64         // 下载速度(MiB/s)，未测速时为 0
+	Trace         TraceInfo       // 数据中心查询得到的其他信息，仅 trace 查询方式
+	ColoSource    string          // 数据中心信息的来源，ColoSourceCached 或 ColoSourceLive，未查询到时为空
+	Samples       []time.Duration // 每次成功探测的延迟，tls 模式下包含握手耗时
+}
+
+// TotalLatency 建立连接的平均总耗时，tls 模式下包含握手耗时
+func (r *TestResult) TotalLatency() time.Duration {
+	return r.AvgLatency + r.TLSLatency
+}
+
+func (r *TestResult) Clear() {
+	r.IP = ""
+	r.CIDR = ""
+	r.DataCenter = ""
+	r.Region = ""
+	r.City = ""
+	r.AvgLatency = 0
+	r.TLSLatency = 0
+	r.Latency = LatencyStats{}
+	r.LossRate = 0
+	r.DownloadSpeed = 0
+	r.Trace = TraceInfo{}
+	r.ColoSource = ""
+	r.Samples = nil // 结果以值的形式返回，不能复用样本切片
+}
+
+// 结果的JSON格式，延迟使用毫秒
+type resultJSON struct {
+	IP            string  `json:"ip"`
+	CIDR          string  `json:"cidr"`
+	DataCenter    string  `json:"colo"`
+	ColoSource    string  `json:"colo_source,omitempty"`
+	Region        string  `json:"region"`
+	City          string  `json:"city"`
+	AvgLatency    float64 `json:"latency_ms"`
+	TLSLatency    float64 `json:"tls_latency_ms,omitempty"`
+	MinLatency    float64 `json:"min_ms"`
+	MedianLatency float64 `json:"median_ms"`
+	P90Latency    float64 `json:"p90_ms"`
+	MaxLatency    float64 `json:"max_ms"`
+	Jitter        float64 `json:"jitter_ms"`
+	LossRate      float64 `json:"loss_rate"`
+	DownloadSpeed float64 `json:"download_mib_per_s,omitempty"`
+	Loc           string  `json:"loc,omitempty"`
+	EgressIP      string  `json:"egress_ip,omitempty"`
+	HTTP          string  `json:"http,omitempty"`
+	TLS           string  `json:"tls,omitempty"`
+	Warp          string  `json:"warp,omitempty"`
+}
+
+// Millis 将时长转换为毫秒，保留小数部分
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func fromMillis(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// MarshalJSON 以毫秒输出延迟，不包含原始样本
+func (r TestResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(resultJSON{
+		IP:            r.IP,
+		CIDR:          r.CIDR,
+		DataCenter:    r.DataCenter,
+		ColoSource:    r.ColoSource,
+		Region:        r.Region,
+		City:          r.City,
+		AvgLatency:    Millis(r.AvgLatency),
+		TLSLatency:    Millis(r.TLSLatency),
+		MinLatency:    Millis(r.Latency.Min),
+		MedianLatency: Millis(r.Latency.Median),
+		P90Latency:    Millis(r.Latency.P90),
+		MaxLatency:    Millis(r.Latency.Max),
+		Jitter:        Millis(r.Latency.Jitter),
+		LossRate:      r.LossRate,
+		DownloadSpeed: r.DownloadSpeed,
+		Loc:           r.Trace.Loc,
+		EgressIP:      r.Trace.IP,
+		HTTP:          r.Trace.HTTP,
+		TLS:           r.Trace.TLS,
+		Warp:          r.Trace.Warp,
+	})
+}
+
+// UnmarshalJSON 读取 MarshalJSON 输出的结果
+func (r *TestResult) UnmarshalJSON(data []byte) error {
+	var v resultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = TestResult{
+		IP:         v.IP,
+		CIDR:       v.CIDR,
+		DataCenter: v.DataCenter,
+		ColoSource: v.ColoSource,
+		Region:     v.Region,
+		City:       v.City,
+		AvgLatency: fromMillis(v.AvgLatency),
+		TLSLatency: fromMillis(v.TLSLatency),
+		Latency: LatencyStats{
+			Min:    fromMillis(v.MinLatency),
+			Median: fromMillis(v.MedianLatency),
+			P90:    fromMillis(v.P90Latency),
+			Max:    fromMillis(v.MaxLatency),
+			Jitter: fromMillis(v.Jitter),
+		},
+		LossRate:      v.LossRate,
+		DownloadSpeed: v.DownloadSpeed,
+		Trace: TraceInfo{
+			Loc:  v.Loc,
+			IP:   v.EgressIP,
+			HTTP: v.HTTP,
+			TLS:  v.TLS,
+			Warp: v.Warp,
+		},
+	}
+	return nil
+}
+
+// 临时测试数据
+type cidrTestData struct {
+	IPs     []string
+	Results []TestResult
+}
+
+// 测试过程中的结构
+type cidrGroup struct {
+	CIDR   string
+	Data   *cidrTestData // 临时数据
+	Result *TestResult
+}
+
+// Location Cloudflare 数据中心位置信息
+// 字段与 https://speed.cloudflare.com/locations 相同，写入缓存时保留全部字段
+type Location struct {
+	Iata   string  `json:"iata"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Cca2   string  `json:"cca2"`
+	Region string  `json:"region"`
+	City   string  `json:"city"`
+}
+
+// 收到CIDR内全部IP的结果后调用，合并为一个结果
+// 丢包率为全部IP的平均值，完全失败的IP按 100% 计入；延迟只统计有成功探测的IP。
+// 全部IP都失败时没有可用的延迟数据，不产生结果
+func (g *cidrGroup) finalize() {
+	if len(g.Data.Results) > 0 {
+		// 计算平均值
+		var totalLatency, totalTLSLatency time.Duration
+		var totalLossRate float64
+		var successCount int
+		var samples []time.Duration
+		var best, colo *TestResult
+		for i := range g.Data.Results {
+			r := &g.Data.Results[i]
+			totalLossRate += r.LossRate
+			if r.LossRate >= 1 {
+				continue
+			}
+
+			successCount++
+			totalLatency += r.AvgLatency
+			totalTLSLatency += r.TLSLatency
+			samples = append(samples, r.Samples...)
+			if best == nil || r.TotalLatency() < best.TotalLatency() {
+				best = r
+			}
+			// 优先使用查询到数据中心的IP
+			if colo == nil || (colo.DataCenter == "Unknown" && r.DataCenter != "Unknown") {
+				colo = r
+			}
+		}
+
+		if successCount > 0 {
+			// 从对象池获取结果对象
+			g.Result = resultPool.Get().(*TestResult)
+			g.Result.Clear()
+
+			// 填充结果
+			g.Result.IP = best.IP
+			g.Result.CIDR = g.CIDR
+			g.Result.DataCenter = colo.DataCenter
+			g.Result.Region = colo.Region
+			g.Result.City = colo.City
+			g.Result.Trace = colo.Trace
+			g.Result.ColoSource = colo.ColoSource
+			g.Result.AvgLatency = totalLatency / time.Duration(successCount)
+			g.Result.TLSLatency = totalTLSLatency / time.Duration(successCount)
+			g.Result.Latency = computeStats(samples)
+			g.Result.LossRate = totalLossRate / float64(len(g.Data.Results))
+			g.Result.Samples = samples
+		}
+
+		// 清理临时数据并放回对象池
+		g.Data.Results = g.Data.Results[:0]
+		g.Data.IPs = g.Data.IPs[:0]
+		testDataPool.Put(g.Data)
+		g.Data = nil
+	}
+}
@@ -0,0 +1,682 @@
+// Package scan 提供 CloudFlare CIDR 测速的核心逻辑，
+// 命令行工具 cfspeed 只是它之上的一层参数解析和结果输出。
+package scan
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// LogFunc 输出运行过程中的提示信息，为 nil 时不输出
+type LogFunc func(format string, args ...interface{})
+
+func (f LogFunc) printf(format string, args ...interface{}) {
+	if f != nil {
+		f(format, args...)
+	}
+}
+
+// ProgressFunc 报告测试进度，done 为已完成的IP数量，total 为IP总数。
+// 每一轮测试开始时以 done 为 0 调用，自适应测速会有两轮
+type ProgressFunc func(done, total int)
+
+// Options 测速配置
+type Options struct {
+	Port      int // 测试端口号
+	TestCount int // 每个IP的延迟测试次数，小于 1 时 Run 返回错误
+	IPPerCIDR int // 每个CIDR随机选择的不重复IP数量，CIDR中的IP不足时测试全部IP，小于 1 时 Run 返回错误
+	Threads   int // 并发数，最大 1024
+
+	// 筛选条件
+	Colo        []string      // 匹配的数据中心，为空表示不限制
+	MinLatency  time.Duration // 延迟下限
+	MaxLatency  time.Duration // 延迟上限
+	MaxLossRate float64       // 丢包率上限
+	ShowAll     bool          // 保留未查询到数据中心的结果
+
+	// 延迟筛选和排序使用的指标，见 Metrics，为空时使用 avg
+	FilterMetric string
+	SortMetric   string
+
+	// 保留每个IP的测试结果，可通过 IPResults 获取
+	KeepIPResults bool
+
+	// 断点，不为 nil 时跳过其中已完成的CIDR，并定期保存新完成的CIDR
+	Checkpoint         *Checkpoint
+	CheckpointInterval time.Duration // 断点保存间隔，为 0 时使用 30 秒
+
+	// 自适应测速：先对每个CIDR的1个IP探测1次进行粗筛，
+	// 只对延迟和丢包符合条件的CIDR按 IPPerCIDR 和 TestCount 进行完整测试
+	Adaptive bool
+
+	// 数据中心位置信息，可通过 LoadLocations 获取
+	Locations map[string]*Location
+
+	// 数据中心查询方式，见 ColoModeRay 和 ColoModeTrace，为空时使用 ray
+	ColoMode string
+
+	// 数据中心查询请求，用于 cloudflare.com 不可用的IP段或屏蔽80端口的网络。
+	// 协议为 http 或 https，为空时使用 http；端口为 0 时使用协议的默认端口；
+	// Host 为空时使用 DefaultColoHost，SNI 为空时与 Host 相同；
+	// 路径为空时 ray 方式使用 /，trace 方式使用 /cdn-cgi/trace
+	ColoScheme string
+	ColoPort   int
+	ColoHost   string
+	ColoSNI    string
+	ColoPath   string
+
+	// 数据中心缓存，不为 nil 时缓存中未过期的CIDR不再查询，实时查询的结果写入缓存，
+	// Run 结束时保存
+	ColoCache *ColoCache
+
+	// 数据中心查询的超时和重试，超时为 0 时使用 1 秒
+	ColoTimeout    time.Duration
+	ColoRetries    int
+	ColoRetryDelay time.Duration
+
+	// 探测方式，为 nil 时使用TCP连接
+	Prober Prober
+
+	// 选取测试IP使用的随机数生成器，为 nil 时使用当前时间作为种子。
+	// IP在分发任务时依次生成，相同种子的两次运行测试相同的IP
+	Rand *rand.Rand
+
+	// 实时获取结果：OnResult 在每个CIDR合并且符合筛选条件后调用，断点中已完成的结果在测试开始前调用；
+	// OnIPResult 在每个符合筛选条件的IP测试完成后调用。两者依次调用，不会并发执行，
+	// 耗时的处理会阻塞测试；自适应测速的粗筛阶段不调用
+	OnResult   func(result TestResult)
+	OnIPResult func(result TestResult)
+
+	Logf     LogFunc
+	Progress ProgressFunc
+}
+
+// DefaultOptions 返回与命令行默认值一致的配置
+func DefaultOptions() Options {
+	return Options{
+		Port:        443,
+		TestCount:   4,
+		IPPerCIDR:   2,
+		Threads:     128,
+		MaxLatency:  500 * time.Millisecond,
+		MaxLossRate: 0.5,
+
+		ColoTimeout:    time.Second,
+		ColoRetries:    2,
+		ColoRetryDelay: 800 * time.Millisecond,
+	}
+}
+
+// Scanner 对一组CIDR进行延迟测试和数据中心查询
+type Scanner struct {
+	opts   Options
+	sem    *semaphore.Weighted
+	prober Prober
+
+	ipMutex   sync.Mutex
+	ipResults []TestResult // 每个IP的测试结果，仅 KeepIPResults 时记录
+}
+
+var (
+	testDataPool   sync.Pool
+	resultPool     sync.Pool
+	testResultPool sync.Pool
+)
+
+func init() {
+	// 初始化对象池
+	testDataPool = sync.Pool{
+		New: func() interface{} {
+			return &cidrTestData{
+				IPs:     make([]string, 0),
+				Results: make([]TestResult, 0),
+			}
+		},
+	}
+
+	// 初始化 resultPool
+	resultPool = sync.Pool{
+		New: func() interface{} {
+			return &TestResult{}
+		},
+	}
+
+	// 初始化 TestResult 对象池
+	testResultPool = sync.Pool{
+		New: func() interface{} {
+			return &TestResult{}
+		},
+	}
+}
+
+// New 根据配置创建 Scanner
+func New(opts Options) *Scanner {
+	// 限制最大并发数为1024
+	if opts.Threads > 1024 {
+		opts.Threads = 1024
+	}
+	if opts.Threads < 1 {
+		opts.Threads = 1
+	}
+	if opts.Locations == nil {
+		opts.Locations = make(map[string]*Location)
+	}
+	if opts.ColoTimeout <= 0 {
+		opts.ColoTimeout = time.Second
+	}
+	if opts.ColoRetries < 0 {
+		opts.ColoRetries = 0
+	}
+	if opts.ColoMode == "" {
+		opts.ColoMode = ColoModeRay
+	}
+	if opts.ColoScheme == "" {
+		opts.ColoScheme = "http"
+	}
+	if opts.ColoHost == "" {
+		opts.ColoHost = DefaultColoHost
+	}
+	if opts.ColoSNI == "" {
+		opts.ColoSNI = opts.ColoHost
+	}
+	if opts.ColoPath != "" && !strings.HasPrefix(opts.ColoPath, "/") {
+		opts.ColoPath = "/" + opts.ColoPath
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = 30 * time.Second
+	}
+	prober := opts.Prober
+	if prober == nil {
+		prober = &TCPProber{Timeout: time.Second}
+	}
+	opts.Rand = ensureRand(opts.Rand)
+
+	return &Scanner{
+		opts: opts,
+		// 使用统一的并发控制
+		sem:    semaphore.NewWeighted(int64(opts.Threads)),
+		prober: prober,
+	}
+}
+
+// 检查无法自动修正的配置
+func (opts *Options) check() error {
+	if opts.TestCount < 1 {
+		return fmt.Errorf("每个IP的延迟测试次数必须大于 0，当前为 %d", opts.TestCount)
+	}
+	if opts.IPPerCIDR < 1 {
+		return fmt.Errorf("每个CIDR测试的IP数量必须大于 0，当前为 %d", opts.IPPerCIDR)
+	}
+	return nil
+}
+
+// Run 对每个CIDR随机选择IP进行测试，返回符合筛选条件的CIDR结果，
+// 按丢包率和平均延迟升序排列。cidrs 中的每一项作为一个测试组，
+// 需要拆分的大段请先调用 SplitCIDRs。
+// ctx 取消后停止分发新的IP，等待进行中的测试完成，返回已完成的CIDR结果和 ctx.Err()。
+func (s *Scanner) Run(ctx context.Context, cidrs []string) ([]TestResult, error) {
+	if err := s.opts.check(); err != nil {
+		return nil, err
+	}
+
+	s.ipMutex.Lock()
+	s.ipResults = nil
+	s.ipMutex.Unlock()
+
+	// 跳过断点中已完成的CIDR
+	var resumed []TestResult
+	if s.opts.Checkpoint != nil {
+		cidrs, resumed = s.skipCompleted(cidrs)
+		if s.opts.OnResult != nil {
+			for _, result := range resumed {
+				s.opts.OnResult(result)
+			}
+		}
+	}
+
+	// 自适应测速先进行粗筛
+	if s.opts.Adaptive {
+		cidrs = s.coarseScan(ctx, cidrs)
+	}
+
+	cidrGroups := make([]cidrGroup, len(cidrs))
+	for i, cidr := range cidrs {
+		cidrGroups[i] = cidrGroup{
+			CIDR: cidr,
+		}
+	}
+
+	// 测试IP性能
+	if s.opts.Checkpoint != nil {
+		stopSave := s.opts.Checkpoint.autoSave(s.opts.CheckpointInterval, s.opts.Logf)
+		cidrGroups = s.testIPs(ctx, cidrGroups, s.fullPass())
+		stopSave()
+	} else {
+		cidrGroups = s.testIPs(ctx, cidrGroups, s.fullPass())
+	}
+
+	// 收集已合并的结果
+	var results []TestResult
+	for _, group := range cidrGroups {
+		if group.Result != nil {
+			results = append(results, *group.Result)
+
+			// 回收 Result 对象
+			resultPool.Put(group.Result)
+			group.Result = nil
+		}
+	}
+
+	results = append(results, resumed...)
+	sortResults(results, s.opts.SortMetric)
+
+	if s.opts.ColoCache != nil {
+		if err := s.opts.ColoCache.Save(); err != nil {
+			s.opts.Logf.printf("保存数据中心缓存失败: %v\n", err)
+		}
+	}
+
+	return results, ctx.Err()
+}
+
+// 从 cidrs 中去掉断点里已完成的CIDR，返回剩余的CIDR和已完成且符合筛选条件的结果
+func (s *Scanner) skipCompleted(cidrs []string) ([]string, []TestResult) {
+	var remaining []string
+	var resumed []TestResult
+	for _, cidr := range cidrs {
+		result, ok := s.opts.Checkpoint.lookup(cidr)
+		if !ok {
+			remaining = append(remaining, cidr)
+			continue
+		}
+		if result != nil && s.shouldInclude(result) {
+			resumed = append(resumed, *result)
+		}
+	}
+
+	if skipped := len(cidrs) - len(remaining); skipped > 0 {
+		s.opts.Logf.printf("从断点恢复: 跳过 %d 个已完成的CIDR，剩余 %d 个\n", skipped, len(remaining))
+	}
+	return remaining, resumed
+}
+
+// IPResults 返回上一次 Run 中符合筛选条件的单个IP结果，排序方式与 Run 相同，
+// 需要在配置中启用 KeepIPResults
+func (s *Scanner) IPResults() []TestResult {
+	s.ipMutex.Lock()
+	defer s.ipMutex.Unlock()
+
+	var results []TestResult
+	for i := range s.ipResults {
+		if s.shouldInclude(&s.ipResults[i]) {
+			results = append(results, s.ipResults[i])
+		}
+	}
+	sortResults(results, s.opts.SortMetric)
+	return results
+}
+
+// 按丢包率和指定的延迟指标升序排列
+func sortResults(results []TestResult, metric string) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].LossRate == results[j].LossRate {
+			return results[i].Metric(metric) < results[j].Metric(metric)
+		}
+		return results[i].LossRate < results[j].LossRate
+	})
+}
+
+// shouldInclude 检查结果是否符合过滤条件
+func (s *Scanner) shouldInclude(result *TestResult) bool {
+	// 如果不显示所有结果，则跳过未知数据中心的结果
+	if !s.opts.ShowAll && result.DataCenter == "Unknown" {
+		return false
+	}
+
+	// 检查数据中心
+	if len(s.opts.Colo) > 0 {
+		match := false
+		for _, colo := range s.opts.Colo {
+			if result.DataCenter == colo {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+
+	// 检查延迟
+	latency := result.Metric(s.opts.FilterMetric)
+	if latency < s.opts.MinLatency || latency > s.opts.MaxLatency {
+		return false
+	}
+
+	// 检查丢包率
+	if result.LossRate > s.opts.MaxLossRate {
+		return false
+	}
+
+	return true
+}
+
+// CIDR数据中心信息缓存
+type cidrCache struct {
+	sync.RWMutex
+	dataCenter *string
+	region     *string
+	city       *string
+	trace      TraceInfo
+	source     string // ColoSourceCached 或 ColoSourceLive
+	found      bool
+}
+
+// 单个IP的测试任务和结果，group 为所属 CIDR 组的下标
+type ipTask struct {
+	group  int
+	result TestResult
+}
+
+// 分发给工作协程的测试任务
+type ipJob struct {
+	group int
+	ip    string
+}
+
+// 测试IP性能
+// 每个被测试的IP无论成功与否都会上报，CIDR 组在收到全部IP的结果后才会合并
+func (s *Scanner) testIPs(ctx context.Context, cidrGroups []cidrGroup, pass scanPass) []cidrGroup {
+	maxThreads := s.opts.Threads
+	ipPerCIDR := pass.ipPerCIDR
+
+	var wg sync.WaitGroup
+
+	taskChan := make(chan ipJob, maxThreads)
+	resultChan := make(chan ipTask, maxThreads)
+
+	// 每个 CIDR 组测试的IP数量，CIDR中的IP不足 ipPerCIDR 个时测试全部IP
+	ipCounts := make([]int, len(cidrGroups))
+
+	// 每个 CIDR 组尚未上报的IP数量
+	remaining := make([]int, len(cidrGroups))
+
+	// 每个 CIDR 组的数据中心信息缓存
+	coloCaches := make([]cidrCache, len(cidrGroups))
+
+	// 初始化每个 CIDR 组的 Data 字段和计数
+	cachedCount := 0
+	totalIPs := 0
+	for i := range cidrGroups {
+		cidrGroups[i].Data = testDataPool.Get().(*cidrTestData)
+		ipCounts[i] = ipPerCIDR
+		if prefix, err := parsePrefix(cidrGroups[i].CIDR); err == nil {
+			if count, ok := hostCount(prefix); ok && count < ipPerCIDR {
+				ipCounts[i] = count
+			}
+		}
+		remaining[i] = ipCounts[i]
+		totalIPs += ipCounts[i]
+
+		// 使用数据中心缓存中未过期的记录
+		if s.opts.ColoCache != nil && !pass.coarse {
+			if entry, ok := s.opts.ColoCache.lookup(s.coloCacheKey(cidrGroups[i].CIDR)); ok {
+				cache := &coloCaches[i]
+				cache.dataCenter = &entry.DataCenter
+				cache.region = &entry.Region
+				cache.city = &entry.City
+				cache.trace = entry.Trace
+				cache.source = ColoSourceCached
+				cache.found = true
+				cachedCount++
+			}
+		}
+	}
+	if cachedCount > 0 {
+		s.opts.Logf.printf("数据中心缓存: %d 个CIDR使用缓存，%d 个CIDR需要查询\n", cachedCount, len(cidrGroups)-cachedCount)
+	}
+
+	// 计数器
+	var (
+		processedCount int32
+		successCount   int32
+	)
+
+	// 报告初始进度
+	if s.opts.Progress != nil {
+		s.opts.Progress(0, totalIPs)
+	}
+
+	// 分发任务，每个 CIDR 组分发 ipCounts 次，同一组的IP不重复。
+	// 测试IP在这里依次生成，使结果只取决于随机数生成器的种子
+	go func() {
+		defer close(taskChan)
+		for i := range cidrGroups {
+			var ips []netip.Addr
+			if prefix, err := parsePrefix(cidrGroups[i].CIDR); err == nil {
+				ips = sampleAddrs(s.opts.Rand, prefix, ipCounts[i])
+			}
+			for j := 0; j < ipCounts[i]; j++ {
+				job := ipJob{group: i}
+				if j < len(ips) {
+					job.ip = ips[j].String()
+				}
+				select {
+				case taskChan <- job:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	// 启动结果处理协程
+	resultDone := make(chan struct{})
+	go func() {
+		defer close(resultDone)
+		for task := range resultChan {
+			if s.opts.KeepIPResults && !pass.coarse {
+				s.ipMutex.Lock()
+				s.ipResults = append(s.ipResults, task.result)
+				s.ipMutex.Unlock()
+			}
+			if s.opts.OnIPResult != nil && !pass.coarse && s.shouldInclude(&task.result) {
+				s.opts.OnIPResult(task.result)
+			}
+
+			// 添加结果到临时存储
+			group := &cidrGroups[task.group]
+			group.Data.Results = append(group.Data.Results, task.result)
+			remaining[task.group]--
+
+			// 收到全部IP的结果后合并
+			if remaining[task.group] == 0 {
+				group.finalize()
+				if s.opts.Checkpoint != nil && !pass.coarse {
+					s.opts.Checkpoint.record(group.CIDR, group.Result)
+				}
+
+				// 检查结果是否符合过滤条件
+				if group.Result != nil && !pass.include(group.Result) {
+					resultPool.Put(group.Result)
+					group.Result = nil
+				}
+				if group.Result != nil && s.opts.OnResult != nil && !pass.coarse {
+					s.opts.OnResult(*group.Result)
+				}
+			}
+		}
+	}()
+
+	// 取消后不再分发新任务，已开始的IP使用不会被取消的上下文完成测试，
+	// 避免被中断的探测计为丢包。已进入任务队列但尚未开始的IP会被丢弃，
+	// 它们所在的组收不到全部结果，不会合并，与未分发的组一样不计入结果
+	probeCtx := context.WithoutCancel(ctx)
+
+	// 创建工作池
+	for i := 0; i < maxThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range taskChan {
+				if ctx.Err() != nil {
+					continue
+				}
+				index := job.group
+				result := s.testIP(probeCtx, cidrGroups[index].CIDR, job.ip, &coloCaches[index], pass)
+				if result.LossRate < 1 {
+					atomic.AddInt32(&successCount, 1)
+				}
+
+				// 发送结果到结果通道
+				resultChan <- ipTask{group: index, result: result}
+
+				// 更新进度
+				current := atomic.AddInt32(&processedCount, 1)
+				if s.opts.Progress != nil {
+					s.opts.Progress(int(current), totalIPs)
+				}
+			}
+		}()
+	}
+
+	// 等待所有工作完成，再等待结果处理协程处理完剩余结果
+	wg.Wait()
+	close(resultChan)
+	<-resultDone
+
+	// 计算测试成功率
+	if totalIPs > 0 {
+		successRate := float64(successCount) / float64(totalIPs) * 100
+		s.opts.Logf.printf("测试完成，成功率: %.2f%% (%d/%d)\n", successRate, successCount, totalIPs)
+	}
+
+	// 过滤结果时只保留有最终结果的组
+	var filteredGroups []cidrGroup
+	for _, group := range cidrGroups {
+		// 只保留有Result且不为nil的组
+		if group.Result != nil {
+			filteredGroups = append(filteredGroups, group)
+		} else if group.Data != nil {
+			// 对于没有最终结果的组，确保其Data被放回对象池
+			group.Data.Results = group.Data.Results[:0]
+			group.Data.IPs = group.Data.IPs[:0]
+			testDataPool.Put(group.Data)
+		}
+	}
+
+	return filteredGroups
+}
+
+// 测试CIDR中的一个IP，全部探测失败时丢包率为 1
+func (s *Scanner) testIP(ctx context.Context, cidr, ip string, cache *cidrCache, pass scanPass) TestResult {
+	resultObj := testResultPool.Get().(*TestResult)
+	resultObj.Clear() // 清空对象
+	defer testResultPool.Put(resultObj)
+
+	// 设置基本信息
+	resultObj.CIDR = cidr
+	resultObj.LossRate = 1
+
+	// CIDR无效时没有可测试的IP
+	if ip == "" {
+		return *resultObj
+	}
+	resultObj.IP = ip
+
+	// 执行探测
+	testCount := pass.testCount
+	localSuccessCount := 0
+	totalLatency := time.Duration(0)
+	totalHandshake := time.Duration(0)
+	var samples []time.Duration
+	for _, attempt := range s.prober.Probe(ctx, ip, s.opts.Port, testCount) {
+		if attempt.Err != nil {
+			continue
+		}
+		localSuccessCount++
+		totalLatency += attempt.Latency
+		totalHandshake += attempt.Handshake
+		samples = append(samples, attempt.Latency+attempt.Handshake)
+	}
+
+	if localSuccessCount == 0 {
+		return *resultObj
+	}
+
+	// 探测成功
+	resultObj.AvgLatency = totalLatency / time.Duration(localSuccessCount)
+	resultObj.TLSLatency = totalHandshake / time.Duration(localSuccessCount)
+	resultObj.Latency = computeStats(samples)
+	resultObj.Samples = samples
+	resultObj.LossRate = float64(testCount-localSuccessCount) / float64(testCount)
+
+	// 粗筛阶段不查询数据中心
+	if pass.coarse {
+		return *resultObj
+	}
+
+	// 检查CIDR是否已有数据中心信息
+	cache.RLock()
+	if cache.found {
+		resultObj.DataCenter = *cache.dataCenter
+		resultObj.Region = *cache.region
+		resultObj.City = *cache.city
+		resultObj.Trace = cache.trace
+		resultObj.ColoSource = cache.source
+		cache.RUnlock()
+		return *resultObj
+	}
+	cache.RUnlock()
+
+	dataCenter, region, city, trace := s.LookupColo(ctx, ip)
+	if dataCenter != "Unknown" {
+		cache.Lock()
+		if !cache.found {
+			// 查找locationMap中是否有该数据中心
+			if loc, ok := s.opts.Locations[dataCenter]; ok {
+				// 使用指针指向locationMap中的数据
+				cache.dataCenter = &dataCenter
+				cache.region = &loc.Region
+				cache.city = &loc.City
+			} else {
+				// 如果locationMap中没有，则创建新的字符串
+				dcCopy := dataCenter
+				regionCopy := region
+				cityCopy := city
+				cache.dataCenter = &dcCopy
+				cache.region = &regionCopy
+				cache.city = &cityCopy
+			}
+			cache.trace = trace
+			cache.source = ColoSourceLive
+			cache.found = true
+
+			if s.opts.ColoCache != nil {
+				s.opts.ColoCache.store(s.coloCacheKey(cidr), coloCacheEntry{
+					DataCenter: dataCenter,
+					Region:     *cache.region,
+					City:       *cache.city,
+					Trace:      trace,
+				})
+			}
+		}
+		cache.Unlock()
+		resultObj.ColoSource = ColoSourceLive
+	}
+	resultObj.DataCenter = dataCenter
+	resultObj.Region = region
+	resultObj.City = city
+	resultObj.Trace = trace
+
+	return *resultObj
+}
@@ -0,0 +1,80 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// 记录探测次数的 Prober，第一次探测时调用 onProbe
+type countingProber struct {
+	probes  int32
+	onProbe func()
+}
+
+func (p *countingProber) Probe(ctx context.Context, ip string, port, count int) []Attempt {
+	if atomic.AddInt32(&p.probes, 1) == 1 && p.onProbe != nil {
+		p.onProbe()
+	}
+	attempts := make([]Attempt, count)
+	for i := range attempts {
+		attempts[i].Err = errors.New("unreachable")
+	}
+	return attempts
+}
+
+func TestRunStopsQueuedJobsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	prober := &countingProber{onProbe: cancel}
+	opts := DefaultOptions()
+	opts.Threads = 4
+	opts.IPPerCIDR = 1
+	opts.TestCount = 1
+	opts.Prober = prober
+
+	var cidrs []string
+	for i := 0; i < 64; i++ {
+		cidrs = append(cidrs, fmt.Sprintf("10.0.0.%d/32", i))
+	}
+
+	_, err := New(opts).Run(ctx, cidrs)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// 取消时最多有 Threads 个IP正在测试，队列中的IP不应再被探测
+	if probes := atomic.LoadInt32(&prober.probes); probes > int32(opts.Threads) {
+		t.Errorf("probed %d IPs after cancel, want at most %d", probes, opts.Threads)
+	}
+}
+
+func TestRunRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*Options)
+	}{
+		{"zero test count", func(o *Options) { o.TestCount = 0 }},
+		{"negative test count", func(o *Options) { o.TestCount = -1 }},
+		{"zero IPs per CIDR", func(o *Options) { o.IPPerCIDR = 0 }},
+		{"negative IPs per CIDR", func(o *Options) { o.IPPerCIDR = -1 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober := &countingProber{}
+			opts := DefaultOptions()
+			opts.Prober = prober
+			tt.set(&opts)
+
+			if _, err := New(opts).Run(context.Background(), []string{"10.0.0.0/30"}); err == nil {
+				t.Fatal("want error")
+			}
+			if prober.probes != 0 {
+				t.Errorf("probed %d times, want 0", prober.probes)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package scan
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// LatencyStats 延迟分布统计
+type LatencyStats struct {
+	Min    time.Duration
+	Median time.Duration
+	P90    time.Duration
+	Max    time.Duration
+	Jitter time.Duration // 标准差
+}
+
+// 可用于排序和筛选的延迟指标
+const (
+	MetricAvg    = "avg"
+	MetricMin    = "min"
+	MetricMedian = "median"
+	MetricP90    = "p90"
+	MetricMax    = "max"
+	MetricJitter = "jitter"
+)
+
+// Metrics 全部延迟指标名称
+var Metrics = []string{MetricAvg, MetricMin, MetricMedian, MetricP90, MetricMax, MetricJitter}
+
+// CheckMetric 检查延迟指标名称是否有效，空字符串视为 avg
+func CheckMetric(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, metric := range Metrics {
+		if name == metric {
+			return nil
+		}
+	}
+	return fmt.Errorf("不支持的延迟指标: %s", name)
+}
+
+// Metric 按名称返回延迟指标，avg 为 TotalLatency，未知名称同样返回 avg
+func (r *TestResult) Metric(name string) time.Duration {
+	switch name {
+	case MetricMin:
+		return r.Latency.Min
+	case MetricMedian:
+		return r.Latency.Median
+	case MetricP90:
+		return r.Latency.P90
+	case MetricMax:
+		return r.Latency.Max
+	case MetricJitter:
+		return r.Latency.Jitter
+	default:
+		return r.TotalLatency()
+	}
+}
+
+// 计算延迟样本的分布统计，samples 会被排序
+func computeStats(samples []time.Duration) LatencyStats {
+	n := len(samples)
+	if n == 0 {
+		return LatencyStats{}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+
+	var stats LatencyStats
+	stats.Min = samples[0]
+	stats.Max = samples[n-1]
+
+	// 中位数，偶数个样本取中间两个的平均值
+	if n%2 == 1 {
+		stats.Median = samples[n/2]
+	} else {
+		stats.Median = (samples[n/2-1] + samples[n/2]) / 2
+	}
+
+	// P90 使用最近秩法
+	rank := int(math.Ceil(0.9*float64(n))) - 1
+	stats.P90 = samples[rank]
+
+	// 抖动使用总体标准差
+	var sum float64
+	for _, sample := range samples {
+		sum += float64(sample)
+	}
+	mean := sum / float64(n)
+	var variance float64
+	for _, sample := range samples {
+		diff := float64(sample) - mean
+		variance += diff * diff
+	}
+	stats.Jitter = time.Duration(math.Sqrt(variance / float64(n)))
+
+	return stats
+}